@@ -3,7 +3,6 @@ package assets
 import (
 	"archive/zip"
 	"context"
-	"fmt"
 	"io"
 
 	"cloud.google.com/go/storage"
@@ -14,8 +13,11 @@ import (
 	"path/filepath"
 
 	"encoding/gob"
+	"fmt"
 
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/s3"
 	"github.com/dave/patsy"
 	"github.com/dave/patsy/vos"
 	"github.com/gopherjs/gopherjs/compiler"
@@ -27,13 +29,15 @@ var Assets = memfs.New()
 var Archives map[string]map[bool]*compiler.Archive
 var Source map[string]map[string]string
 
-func Init() {
-	if err := loadAssets(Assets); err != nil {
+// Init loads the assets. Outside of dev mode they're read from the pkg bucket of the S3-compatible object
+// store if objects is not nil, or from GCS otherwise.
+func Init(objects *s3.Client) {
+	if err := loadAssets(Assets, objects); err != nil {
 		panic(err)
 	}
 }
 
-func loadAssets(fs billy.Filesystem) error {
+func loadAssets(fs billy.Filesystem, objects *s3.Client) error {
 
 	var buf *bytes.Buffer
 
@@ -47,6 +51,16 @@ func loadAssets(fs billy.Filesystem) error {
 			return err
 		}
 		buf = bytes.NewBuffer(b)
+	} else if objects != nil {
+		logger.Default.Info("Getting assets from S3")
+		buf = new(bytes.Buffer)
+		found, err := objects.Get(context.Background(), config.Bucket[config.Pkg], config.AssetsFilename, buf)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s not found in the %s bucket", config.AssetsFilename, config.Bucket[config.Pkg])
+		}
 	} else {
 		ctx := context.Background()
 		client, err := storage.NewClient(ctx)
@@ -58,7 +72,7 @@ func loadAssets(fs billy.Filesystem) error {
 		if err != nil {
 			return err
 		}
-		fmt.Println("Getting assets from GCS...")
+		logger.Default.Info("Getting assets from GCS")
 		buf = new(bytes.Buffer)
 		if _, err := io.Copy(buf, gcsReader); err != nil {
 			return err
@@ -66,7 +80,7 @@ func loadAssets(fs billy.Filesystem) error {
 	}
 
 	reader := bytes.NewReader(buf.Bytes())
-	fmt.Println("Unzipping assets...")
+	logger.Default.Info("Unzipping assets")
 	r, err := zip.NewReader(reader, int64(buf.Len()))
 	if err != nil {
 		return err
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// These are set from the Config by Apply, and default to DefaultPreset.
+var (
+	// DEV serves the pages on localhost (see Host) and uses the dev buckets and kinds.
+	DEV bool
+
+	// LOCAL uses the local fileserver and database, and the repos in GOPATH.
+	LOCAL bool
+
+	// Host is the host of each site, and Protocol its protocol ("http" or "https").
+	Host, Protocol map[string]string
+
+	// Bucket is the storage bucket of each static site and the git cache.
+	Bucket map[string]string
+
+	// Pages mounts the pages at other hosts and path prefixes (see Config.Pages).
+	Pages map[string]string
+
+	// Listeners are the addresses the server listens on.
+	Listeners []Listener
+
+	// Buckets are the values of Bucket.
+	Buckets []string
+
+	// The kinds of entity stored in the database.
+	ErrorKind, CompileKind, PackageKind, DeployKind, ShareKind, HintsKind, WasmDeployKind, HistoryKind, FailureKind string
+
+	// Kinds are the kinds of entity stored in the database.
+	Kinds []string
+
+	// MaxConcurrentCompiles is the maximum number of concurrent compile jobs per server
+	MaxConcurrentCompiles int
+
+	// MaxQueue is the maximum queue length waiting for compile. After this an error is returned.
+	MaxQueue int
+
+	// MaxQueuePerClient is the maximum number of items a single client (see TrustedProxies) may have
+	// waiting in the queue.
+	MaxQueuePerClient int
+
+	// TrustedProxies is the number of proxies in front of the server that append to X-Forwarded-For.
+	TrustedProxies int
+
+	ConcurrentStorageUploads int
+
+	// WriteTimeout is the timeout when serving static files
+	WriteTimeout time.Duration
+
+	// RequestTimeout is the timeout when compiling a package.
+	RequestTimeout time.Duration
+
+	// PageTimeout is the timeout when generating the compile page
+	PageTimeout time.Duration
+
+	// StoreTimeout is the timeout when storing the result of a request after the request context has
+	// ended (e.g. storing a failure after a compile timed out).
+	StoreTimeout time.Duration
+
+	// HttpTimeout is the time to wait for HTTP operations (e.g. getting meta data - not git)
+	HttpTimeout time.Duration
+
+	// ServerShutdownTimeout is the timeout when doing a graceful server shutdown
+	ServerShutdownTimeout time.Duration
+
+	// DrainTimeout is the time running jobs are given to finish after the server receives the shutdown
+	// signal, before they are cancelled. The grace period of the orchestrator should be longer.
+	DrainTimeout time.Duration
+
+	// CancelTimeout is the time cancelled jobs are given to stop after DrainTimeout, before the server
+	// shuts down anyway.
+	CancelTimeout time.Duration
+
+	// WebsocketPingPeriod is the interval between pings. Must be less than WebsocketPongTimeout.
+	WebsocketPingPeriod time.Duration
+
+	// WebsocketPongTimeout is the time to wait for a pong from the client before cancelling
+	WebsocketPongTimeout time.Duration
+
+	// WebsocketWriteTimeout is the write timeout for websockets
+	WebsocketWriteTimeout time.Duration
+
+	// WebsocketInstructionTimeout is the time to wait for instructions from the client (e.g. during
+	// playground compile)
+	WebsocketInstructionTimeout time.Duration
+
+	// JobReconnectTimeout is the time to wait for a client to reconnect to a job after the websocket
+	// drops. After this the job is cancelled.
+	JobReconnectTimeout time.Duration
+
+	// JobRetention is the time a finished job is kept so a client that reconnects can replay the result.
+	JobRetention time.Duration
+
+	// SessionIdleTimeout is the time a multi-command websocket session stays open without any commands.
+	SessionIdleTimeout time.Duration
+)
+
+// Config is the runtime configuration. It's loaded from a preset, a JSON file and the environment
+// variables in the env tags (see Load). Maps are overridden by key, e.g. JSGO_HOST_PLAY.
+type Config struct {
+	Preset string
+
+	Dev   bool `env:"JSGO_DEV"`
+	Local bool `env:"JSGO_LOCAL"`
+
+	Host     map[string]string `env:"JSGO_HOST"`
+	Protocol map[string]string `env:"JSGO_PROTOCOL"`
+	Bucket   map[string]string `env:"JSGO_BUCKET"`
+
+	// Pages maps hosts with an optional path prefix (e.g. "example.com/compile/") to the site whose
+	// page is served there (jsgo, play, frizz or wasm). The host ":8080" matches any host on port 8080.
+	// The sites are also served at the root of their hosts in Host.
+	Pages map[string]string
+
+	// Listeners are the addresses the server listens on. JSGO_LISTEN (e.g. ":8080,:8443") replaces
+	// them with plain HTTP listeners, and PORT sets the port of the first.
+	Listeners []Listener
+
+	// KindSuffix is added to the kinds of entity (e.g. "CompileDev").
+	KindSuffix string `env:"JSGO_KIND_SUFFIX"`
+
+	MaxConcurrentCompiles    int `env:"JSGO_MAX_CONCURRENT_COMPILES"`
+	MaxQueue                 int `env:"JSGO_MAX_QUEUE"`
+	MaxQueuePerClient        int `env:"JSGO_MAX_QUEUE_PER_CLIENT"`
+	ConcurrentStorageUploads int `env:"JSGO_CONCURRENT_STORAGE_UPLOADS"`
+
+	// TrustedProxies is the number of proxies in front of the server (e.g. 1 for a load balancer) that
+	// append the address they received the request from to X-Forwarded-For. The client is identified
+	// by the entry appended by the first of them, so it can't be spoofed. With 0, X-Forwarded-For is
+	// ignored and the client is the remote address.
+	TrustedProxies int `env:"JSGO_TRUSTED_PROXIES"`
+
+	WriteTimeout                Duration `env:"JSGO_WRITE_TIMEOUT"`
+	RequestTimeout              Duration `env:"JSGO_REQUEST_TIMEOUT"`
+	PageTimeout                 Duration `env:"JSGO_PAGE_TIMEOUT"`
+	StoreTimeout                Duration `env:"JSGO_STORE_TIMEOUT"`
+	HttpTimeout                 Duration `env:"JSGO_HTTP_TIMEOUT"`
+	ServerShutdownTimeout       Duration `env:"JSGO_SERVER_SHUTDOWN_TIMEOUT"`
+	DrainTimeout                Duration `env:"DRAIN_TIMEOUT"`
+	CancelTimeout               Duration `env:"JSGO_CANCEL_TIMEOUT"`
+	WebsocketPingPeriod         Duration `env:"JSGO_WEBSOCKET_PING_PERIOD"`
+	WebsocketPongTimeout        Duration `env:"JSGO_WEBSOCKET_PONG_TIMEOUT"`
+	WebsocketWriteTimeout       Duration `env:"JSGO_WEBSOCKET_WRITE_TIMEOUT"`
+	WebsocketInstructionTimeout Duration `env:"JSGO_WEBSOCKET_INSTRUCTION_TIMEOUT"`
+	JobReconnectTimeout         Duration `env:"JSGO_JOB_RECONNECT_TIMEOUT"`
+	JobRetention                Duration `env:"JSGO_JOB_RETENTION"`
+	SessionIdleTimeout          Duration `env:"JSGO_SESSION_IDLE_TIMEOUT"`
+
+	// Git is the configuration of the git fetcher. The bucket is Bucket[Git].
+	GitSaveTimeout  Duration `env:"JSGO_GIT_SAVE_TIMEOUT"`
+	GitCloneTimeout Duration `env:"JSGO_GIT_CLONE_TIMEOUT"`
+	GitMaxObjects   int      `env:"JSGO_GIT_MAX_OBJECTS"`
+}
+
+// Presets are the names of the presets. They replace the dev and local build tags, which now only
+// choose DefaultPreset.
+var Presets = []string{"prod", "dev", "local"}
+
+// Preset returns the named preset.
+func Preset(name string) (Config, error) {
+	c := Config{
+		Preset: name,
+		Host: map[string]string{
+			Jsgo:  "compile.jsgo.io",
+			Play:  "play.jsgo.io",
+			Frizz: "frizz.io",
+			Wasm:  "wasm.jsgo.io",
+			Src:   "src.jsgo.io",
+			Pkg:   "pkg.jsgo.io",
+			Index: "jsgo.io",
+		},
+		Protocol: map[string]string{
+			Jsgo:  "https",
+			Play:  "https",
+			Frizz: "https",
+			Wasm:  "https",
+			Src:   "https",
+			Pkg:   "https",
+			Index: "https",
+		},
+		Bucket: map[string]string{
+			Src:   "src.jsgo.io",
+			Pkg:   "pkg.jsgo.io",
+			Index: "jsgo.io",
+			Git:   "git.jsgo.io",
+		},
+		Pages:                       map[string]string{},
+		Listeners:                   []Listener{{Addr: ":8080"}},
+		MaxConcurrentCompiles:       2,
+		MaxQueue:                    100,
+		MaxQueuePerClient:           5,
+		TrustedProxies:              1,
+		ConcurrentStorageUploads:    10,
+		WriteTimeout:                Duration(time.Second * 2),
+		RequestTimeout:              Duration(time.Second * 300),
+		PageTimeout:                 Duration(time.Second * 5),
+		StoreTimeout:                Duration(time.Second * 5),
+		HttpTimeout:                 Duration(time.Second * 5),
+		ServerShutdownTimeout:       Duration(time.Second * 5),
+		DrainTimeout:                Duration(time.Second * 300),
+		CancelTimeout:               Duration(time.Second * 10),
+		WebsocketPingPeriod:         Duration(time.Second * 10),
+		WebsocketPongTimeout:        Duration(time.Second * 20),
+		WebsocketWriteTimeout:       Duration(time.Second * 20),
+		WebsocketInstructionTimeout: Duration(time.Second * 5),
+		JobReconnectTimeout:         Duration(time.Second * 30),
+		JobRetention:                Duration(time.Second * 60),
+		SessionIdleTimeout:          Duration(time.Minute * 10),
+		GitSaveTimeout:              Duration(time.Second * 300),
+		GitCloneTimeout:             Duration(time.Second * 300),
+		GitMaxObjects:               250000,
+	}
+	switch name {
+	case "prod":
+		return c, nil
+	case "dev", "local":
+		c.Dev = true
+		c.TrustedProxies = 0
+		c.KindSuffix = "Dev"
+		c.Bucket = map[string]string{
+			Src:   "dev-src.jsgo.io",
+			Pkg:   "dev-pkg.jsgo.io",
+			Index: "dev-index.jsgo.io",
+			Git:   "dev-git.jsgo.io",
+		}
+		c.Host = map[string]string{
+			Play:  "localhost:8080",
+			Jsgo:  "localhost:8081",
+			Frizz: "localhost:8082",
+			Wasm:  "localhost:8083",
+			Src:   "dev-src.jsgo.io",
+			Pkg:   "dev-pkg.jsgo.io",
+			Index: "dev-index.jsgo.io",
+		}
+		for _, site := range []string{Jsgo, Play, Frizz, Wasm} {
+			c.Protocol[site] = "http"
+		}
+		c.Listeners = []Listener{{Addr: ":8080"}, {Addr: ":8081"}, {Addr: ":8082"}, {Addr: ":8083"}}
+		// The pages are served on their ports whatever the host (e.g. 127.0.0.1:8080).
+		c.Pages = map[string]string{
+			":8080": Play,
+			":8081": Jsgo,
+			":8082": Frizz,
+			":8083": Wasm,
+		}
+		if name == "local" {
+			c.Local = true
+			c.Host[Src] = "localhost:8091"
+			c.Host[Pkg] = "localhost:8092"
+			c.Host[Index] = "localhost:8093"
+			for _, site := range Static {
+				c.Protocol[site] = "http"
+			}
+		}
+		return c, nil
+	}
+	return Config{}, fmt.Errorf("unknown preset %q (must be one of %v)", name, Presets)
+}
+
+// Listener is an address the server listens on. It serves HTTPS if CertFile and KeyFile are set,
+// otherwise HTTP/1 and, if H2C is set, HTTP/2 without TLS (e.g. behind a proxy that speaks h2c).
+type Listener struct {
+	Addr              string
+	CertFile, KeyFile string `json:",omitempty"`
+	H2C               bool   `json:",omitempty"`
+}
+
+// Duration is a time.Duration encoded in JSON as a string (e.g. "5m0s").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets {
+		c, err := Preset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	local, _ := Preset("local")
+	if !local.Dev || !local.Local || local.Host[Pkg] != "localhost:8092" || local.Protocol[Pkg] != "http" || local.Bucket[Git] != "dev-git.jsgo.io" {
+		t.Fatalf("unexpected local preset %#v", local)
+	}
+	if _, err := Preset("staging"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	contents := `{"Preset": "dev", "Host": {"play": "play.example.com"}, "RequestTimeout": "1m", "MaxConcurrentCompiles": 4}`
+	if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("JSGO_HOST_JSGO", "compile.example.com")
+	os.Setenv("JSGO_MAX_CONCURRENT_COMPILES", "8")
+	defer os.Unsetenv("JSGO_HOST_JSGO")
+	defer os.Unsetenv("JSGO_MAX_CONCURRENT_COMPILES")
+
+	c, err := Load("", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Preset != "dev" || c.KindSuffix != "Dev" || c.Host[Play] != "play.example.com" || c.Host[Jsgo] != "compile.example.com" || c.Host[Frizz] != "localhost:8082" {
+		t.Fatalf("unexpected config %#v", c)
+	}
+	if time.Duration(c.RequestTimeout) != time.Minute || c.MaxConcurrentCompiles != 8 {
+		t.Fatalf("unexpected config %#v", c)
+	}
+
+	os.Setenv("JSGO_WEBSOCKET_PING_PERIOD", "1m")
+	defer os.Unsetenv("JSGO_WEBSOCKET_PING_PERIOD")
+	if _, err := Load("prod", file); err == nil || !strings.Contains(err.Error(), "WebsocketPingPeriod") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"Hosts": {}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("", file); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"Pages": {"example.com/admin/": "admin"}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("", file); err == nil || !strings.Contains(err.Error(), "Pages") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
@@ -21,50 +21,80 @@ const (
 	// ProjectId is the ID of the GCS project
 	ProjectID = "jsgo-192815"
 
-	// CompileHost is the domain of the compile server
-	CompileHost = "compile.jsgo.io"
+	// QueueEstimate is the job duration used to estimate queue wait times before any jobs have finished.
+	QueueEstimate = time.Second * 20
 
-	// MaxConcurrentCompiles is the maximum number of concurrent compile jobs per server
-	MaxConcurrentCompiles = 2
+	AssetsFilename = "assets.zip"
 
-	// MaxQueue is the maximum queue length waiting for compile. After this an error is returned.
-	MaxQueue = 100
+	// TraceExportInterval is the interval between exports of finished spans.
+	TraceExportInterval = time.Second * 5
 
-	AssetsFilename = "assets.zip"
+	// TraceExportTimeout is the timeout when posting spans to the OTLP collector.
+	TraceExportTimeout = time.Second * 10
+
+	// TraceMaxPending is the maximum number of finished spans waiting to be exported.
+	TraceMaxPending = 10000
+
+	// AdminQueryLimit is the number of entities read by each database query of the admin dashboard. The
+	// query is repeated until it reaches the start of the time window.
+	AdminQueryLimit = 1000
+
+	// AdminPageSize is the number of records of each kind listed by the admin dashboard.
+	AdminPageSize = 50
 
-	// WriteTimeout is the timeout when serving static files
-	WriteTimeout = time.Second * 2
+	// AdminSince is the default time window of the admin dashboard.
+	AdminSince = time.Hour * 24
 
-	// CompileTimeout is the timeout when compiling a package.
-	RequestTimeout = time.Second * 300
+	// JobLogSize is the maximum number of messages kept for each job, so they can be replayed to a client
+	// that reconnects.
+	JobLogSize = 1000
 
-	// PageTimeout is the timeout when generating the compile page
-	PageTimeout = time.Second * 5
+	// SessionQueueSize is the maximum number of commands waiting to run in a multi-command websocket
+	// session.
+	SessionQueueSize = 16
 
-	// ServerShutdownTimeout is the timeout when doing a graceful server shutdown
-	ServerShutdownTimeout = time.Second * 5
+	// SessionWarmLimit is the maximum number of warm sessions (one for each set of build tags) kept for a
+	// multi-command websocket session.
+	SessionWarmLimit = 4
 
-	// WebsocketPingPeriod is the interval between pings. Must be less than WebsocketPongTimeout.
-	WebsocketPingPeriod = time.Second * 10
+	// CompileHistorySize is the number of compiles kept in the history of each package.
+	CompileHistorySize = 50
 
-	// WebsocketPongTimeout is the time to wait for a pong from the client before cancelling
-	WebsocketPongTimeout = time.Second * 20
+	// ModuleProxy is the Go module proxy used to download the modules required by go.mod files.
+	ModuleProxy = "https://proxy.golang.org"
 
-	// WebsocketWriteTimeout is the write timeout for websockets
-	WebsocketWriteTimeout = time.Second * 20
+	// ModuleMaxSize is the maximum size of a module zip downloaded from ModuleProxy.
+	ModuleMaxSize = 100 * 1024 * 1024
 
-	// WebsocketInstructionTimeout is the time to wait for instructions from the client (e.g. during
-	// playground compile)
-	WebsocketInstructionTimeout = time.Second * 5
+	// ModuleMaxUnzippedSize is the maximum total size of the files in a module zip, so a small zip of
+	// highly compressed files can't exhaust the memory.
+	ModuleMaxUnzippedSize = 500 * 1024 * 1024
 
-	// HttpTimeout is the time to wait for HTTP operations (e.g. getting meta data - not git)
-	HttpTimeout = time.Second * 5
+	// PinMaxSize is the maximum size of the objects and worktree of a repo cloned to build a pinned
+	// ref. Both are kept in memory.
+	PinMaxSize = 200 * 1024 * 1024
 
-	ConcurrentStorageUploads = 10
+	ConcurrentModuleDownloads = 10
 )
 
-var ValidExtensions = []string{".go", ".jsgo.html", ".inc.js", ".md"}
+// QueueWeights is the relative share of the compile workers given to each queue lane when they are
+// all busy.
+var QueueWeights = map[string]int{
+	Jsgo:  2,
+	Play:  2,
+	Frizz: 1,
+	Wasm:  1,
+}
+
+// WasmClientVersions are the versions of the wasm deploy client (sent in DeployQuery.Version) that the
+// server supports. Other clients are sent DeployClientVersionNotSupported and should be upgraded.
+var WasmClientVersions = []string{"1.0.0"}
 
-var Buckets = []string{Bucket[Src], Bucket[Pkg], Bucket[Index], Bucket[Git]}
+// ProtocolVersions are the versions of the websocket messages the server supports, on all routes. A
+// client can request a version with the subprotocol (e.g. "json.v1"). If it only requests versions
+// that aren't listed, it's sent a version not supported message (see codec.Registry.Protocols).
+var ProtocolVersions = []string{"1"}
+
+var ValidExtensions = []string{".go", ".jsgo.html", ".inc.js", ".md"}
 
 var Static = []string{Src, Pkg, Index}
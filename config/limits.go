@@ -0,0 +1,54 @@
+package config
+
+// RateLimit is a token bucket policy. Rate is the number of tokens added per second and Burst is the
+// size of the bucket. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitPolicy is the rate limit for a route, per client (see TrustedProxies) and per client and
+// requested package path. The path bucket isn't shared between clients, so one client can't lock
+// everyone else out of a popular package.
+type RateLimitPolicy struct {
+	Client RateLimit
+	Path   RateLimit
+}
+
+// SocketRateLimits are the policies for the websocket routes (/_jsgo/, /_play/, /_frizz/ and /_wasm/).
+var SocketRateLimits = map[string]RateLimitPolicy{
+	Jsgo: {
+		Client: RateLimit{Rate: 1.0 / 10, Burst: 5},
+		Path:   RateLimit{Rate: 1.0 / 30, Burst: 3},
+	},
+	Play: {
+		// play sends an Update for every run of the editor, so it needs a much larger allowance.
+		Client: RateLimit{Rate: 1, Burst: 20},
+		Path:   RateLimit{Rate: 1.0 / 5, Burst: 10},
+	},
+	Frizz: {
+		Client: RateLimit{Rate: 1.0 / 5, Burst: 10},
+		Path:   RateLimit{Rate: 1.0 / 10, Burst: 5},
+	},
+	Wasm: {
+		Client: RateLimit{Rate: 1.0 / 10, Burst: 5},
+	},
+}
+
+// PageRateLimits are the policies for the pages served by PageHandler, keyed by page type. Pages only
+// have client limits: a path bucket is shared by all clients, so one client could lock everyone out of
+// a popular page.
+var PageRateLimits = map[string]RateLimitPolicy{
+	Jsgo: {
+		Client: RateLimit{Rate: 1, Burst: 30},
+	},
+	Play: {
+		Client: RateLimit{Rate: 1, Burst: 30},
+	},
+	Frizz: {
+		Client: RateLimit{Rate: 1, Burst: 30},
+	},
+	Wasm: {
+		Client: RateLimit{Rate: 1, Burst: 30},
+	},
+}
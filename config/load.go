@@ -0,0 +1,268 @@
+// +build !js
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	c, err := Preset(DefaultPreset)
+	if err != nil {
+		panic(err)
+	}
+	Apply(c)
+}
+
+// Load loads the configuration. The preset is the first of preset, JSGO_PRESET, the Preset in the file
+// and DefaultPreset. The file (JSON, optional) is applied over the preset, then the environment
+// variables over the file. The result is validated but not applied.
+func Load(preset, file string) (Config, error) {
+	var contents []byte
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return Config{}, err
+		}
+		contents = b
+	}
+
+	if preset == "" {
+		preset = os.Getenv("JSGO_PRESET")
+	}
+	if preset == "" && contents != nil {
+		var v struct{ Preset string }
+		if err := json.Unmarshal(contents, &v); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %v", file, err)
+		}
+		preset = v.Preset
+	}
+	if preset == "" {
+		preset = DefaultPreset
+	}
+
+	c, err := Preset(preset)
+	if err != nil {
+		return Config{}, err
+	}
+	if contents != nil {
+		// Unknown fields are rejected so typos aren't silently ignored.
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %v", file, err)
+		}
+		c.Preset = preset
+	}
+	if err := c.env(); err != nil {
+		return Config{}, err
+	}
+	if s := os.Getenv("JSGO_LISTEN"); s != "" {
+		c.Listeners = nil
+		for _, addr := range strings.Split(s, ",") {
+			c.Listeners = append(c.Listeners, Listener{Addr: strings.TrimSpace(addr)})
+		}
+	}
+	if port := os.Getenv("PORT"); port != "" && len(c.Listeners) > 0 {
+		c.Listeners[0].Addr = ":" + port
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// env overrides the fields from the environment variables in their env tags.
+func (c *Config) env() error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Map {
+			for _, key := range field.MapKeys() {
+				s, ok := os.LookupEnv(name + "_" + strings.ToUpper(key.String()))
+				if ok {
+					field.SetMapIndex(key, reflect.ValueOf(s))
+				}
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := parse(field, s); err != nil {
+			return fmt.Errorf("parsing %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func parse(field reflect.Value, s string) error {
+	switch field.Interface().(type) {
+	case Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(Duration(d)))
+	case string:
+		field.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate returns an error listing all the problems with the configuration.
+func (c Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	for _, site := range []string{Jsgo, Play, Frizz, Wasm, Src, Pkg, Index} {
+		if c.Host[site] == "" {
+			add("Host[%q] is empty", site)
+		}
+		if p := c.Protocol[site]; p != "http" && p != "https" {
+			add("Protocol[%q] is %q (must be http or https)", site, p)
+		}
+	}
+	for _, site := range []string{Src, Pkg, Index, Git} {
+		if c.Bucket[site] == "" {
+			add("Bucket[%q] is empty", site)
+		}
+	}
+	for mount, site := range c.Pages {
+		if site != Jsgo && site != Play && site != Frizz && site != Wasm {
+			add("Pages[%q] is %q (must be jsgo, play, frizz or wasm)", mount, site)
+		}
+		if mount == "" || strings.HasPrefix(mount, "/") {
+			add("Pages[%q] has no host", mount)
+		}
+	}
+	if len(c.Listeners) == 0 {
+		add("Listeners is empty")
+	}
+	for i, l := range c.Listeners {
+		if l.Addr == "" {
+			add("Listeners[%d] has no Addr", i)
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			add("Listeners[%d] needs both CertFile and KeyFile for TLS", i)
+		}
+		if l.H2C && l.CertFile != "" {
+			add("Listeners[%d] has H2C and TLS (HTTP/2 is negotiated over TLS)", i)
+		}
+	}
+	if c.MaxConcurrentCompiles < 1 {
+		add("MaxConcurrentCompiles is %d (must be at least 1)", c.MaxConcurrentCompiles)
+	}
+	if c.MaxQueue < 0 || c.MaxQueuePerClient < 0 || c.TrustedProxies < 0 {
+		add("MaxQueue, MaxQueuePerClient and TrustedProxies must not be negative")
+	}
+	if c.ConcurrentStorageUploads < 1 {
+		add("ConcurrentStorageUploads is %d (must be at least 1)", c.ConcurrentStorageUploads)
+	}
+	if c.GitMaxObjects < 1 {
+		add("GitMaxObjects is %d (must be at least 1)", c.GitMaxObjects)
+	}
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		if d, ok := v.Field(i).Interface().(Duration); ok && d <= 0 {
+			add("%s is %v (must be positive)", v.Type().Field(i).Name, time.Duration(d))
+		}
+	}
+	if c.WebsocketPingPeriod >= c.WebsocketPongTimeout {
+		add("WebsocketPingPeriod must be less than WebsocketPongTimeout")
+	}
+	if c.Local && !c.Dev {
+		add("Local needs Dev")
+	}
+	if problems != nil {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Apply sets the package variables from the configuration.
+func Apply(c Config) {
+	DEV = c.Dev
+	LOCAL = c.Local
+	Host = c.Host
+	Protocol = c.Protocol
+	Bucket = c.Bucket
+	Pages = c.Pages
+	Listeners = c.Listeners
+	Buckets = []string{Bucket[Src], Bucket[Pkg], Bucket[Index], Bucket[Git]}
+
+	ErrorKind = "Error" + c.KindSuffix
+	CompileKind = "Compile" + c.KindSuffix
+	PackageKind = "Package" + c.KindSuffix
+	DeployKind = "Deploy" + c.KindSuffix
+	ShareKind = "Share" + c.KindSuffix
+	HintsKind = "Hints" + c.KindSuffix
+	WasmDeployKind = "WasmDeploy" + c.KindSuffix
+	HistoryKind = "History" + c.KindSuffix
+	FailureKind = "Failure" + c.KindSuffix
+	Kinds = []string{ErrorKind, CompileKind, PackageKind, DeployKind, ShareKind, HintsKind, WasmDeployKind, HistoryKind, FailureKind}
+
+	MaxConcurrentCompiles = c.MaxConcurrentCompiles
+	MaxQueue = c.MaxQueue
+	MaxQueuePerClient = c.MaxQueuePerClient
+	TrustedProxies = c.TrustedProxies
+	ConcurrentStorageUploads = c.ConcurrentStorageUploads
+
+	WriteTimeout = time.Duration(c.WriteTimeout)
+	RequestTimeout = time.Duration(c.RequestTimeout)
+	PageTimeout = time.Duration(c.PageTimeout)
+	StoreTimeout = time.Duration(c.StoreTimeout)
+	HttpTimeout = time.Duration(c.HttpTimeout)
+	ServerShutdownTimeout = time.Duration(c.ServerShutdownTimeout)
+	DrainTimeout = time.Duration(c.DrainTimeout)
+	CancelTimeout = time.Duration(c.CancelTimeout)
+	WebsocketPingPeriod = time.Duration(c.WebsocketPingPeriod)
+	WebsocketPongTimeout = time.Duration(c.WebsocketPongTimeout)
+	WebsocketWriteTimeout = time.Duration(c.WebsocketWriteTimeout)
+	WebsocketInstructionTimeout = time.Duration(c.WebsocketInstructionTimeout)
+	JobReconnectTimeout = time.Duration(c.JobReconnectTimeout)
+	JobRetention = time.Duration(c.JobRetention)
+	SessionIdleTimeout = time.Duration(c.SessionIdleTimeout)
+
+	applyServices(c)
+}
+
+// Print writes the configuration as JSON.
+func (c Config) Print(w io.Writer) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,18 @@
+package config
+
+// SocketOrigins lists the pages (keys of Host and Protocol) that may open a websocket on each route.
+var SocketOrigins = map[string][]string{
+	Jsgo:  {Jsgo},
+	Play:  {Play},
+	Frizz: {Frizz},
+	Wasm:  {Wasm},
+}
+
+// EmbedOrigins is the allow-list of third-party origins (e.g. "https://example.com") that may open a
+// websocket on each route. Use "*" to allow any origin.
+var EmbedOrigins = map[string][]string{
+	Jsgo:  {},
+	Play:  {},
+	Frizz: {},
+	Wasm:  {},
+}
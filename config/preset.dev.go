@@ -0,0 +1,5 @@
+// +build dev,!local
+
+package config
+
+const DefaultPreset = "dev"
@@ -0,0 +1,5 @@
+// +build local
+
+package config
+
+const DefaultPreset = "local"
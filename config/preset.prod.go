@@ -0,0 +1,6 @@
+// +build !dev,!local
+
+package config
+
+// DefaultPreset is the preset used when none is given. It's chosen by the dev and local build tags.
+const DefaultPreset = "prod"
@@ -9,17 +9,24 @@ import (
 	"github.com/dave/services/fetcher/gitfetcher"
 )
 
-var GitFetcherConfig = gitfetcher.Config{
-	GitSaveTimeout:  time.Second * 300,
-	GitCloneTimeout: time.Second * 300,
-	GitMaxObjects:   250000,
-	GitBucket:       Bucket[Git],
-}
+// GitFetcherConfig and DeployerConfig are set by Apply.
+var (
+	GitFetcherConfig gitfetcher.Config
+	DeployerConfig   deployer.Config
+)
 
-var DeployerConfig = deployer.Config{
-	ConcurrentStorageUploads: ConcurrentStorageUploads,
-	IndexBucket:              Bucket[Index],
-	PkgBucket:                Bucket[Pkg],
-	PkgProtocol:              Protocol[Pkg],
-	PkgHost:                  Host[Pkg],
+func applyServices(c Config) {
+	GitFetcherConfig = gitfetcher.Config{
+		GitSaveTimeout:  time.Duration(c.GitSaveTimeout),
+		GitCloneTimeout: time.Duration(c.GitCloneTimeout),
+		GitMaxObjects:   c.GitMaxObjects,
+		GitBucket:       c.Bucket[Git],
+	}
+	DeployerConfig = deployer.Config{
+		ConcurrentStorageUploads: c.ConcurrentStorageUploads,
+		IndexBucket:              c.Bucket[Index],
+		PkgBucket:                c.Bucket[Pkg],
+		PkgProtocol:              c.Protocol[Pkg],
+		PkgHost:                  c.Host[Pkg],
+	}
 }
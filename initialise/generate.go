@@ -1,9 +1,9 @@
 package main
 
-//go:generate go run -tags "dev local" ./initialise.go
+//go:generate go run ./initialise.go -preset local
 
-// -tags ""            # PRODUCTION (Production Google Cloud endpoints)
-// -tags "dev"         # DEVELOPMENT (Testing Google Cloud endpoints)
-// -tags "dev local"   # LOCAL (Local mock endpoints)
+// -preset prod        # PRODUCTION (Production Google Cloud endpoints)
+// -preset dev         # DEVELOPMENT (Testing Google Cloud endpoints)
+// -preset local       # LOCAL (Local mock endpoints)
 
 // Add "-frizz" after "./initialise.go" for experimental Frizz additions
@@ -16,7 +16,6 @@ import (
 	"go/types"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,6 +28,8 @@ import (
 	"github.com/dave/jsgo/config"
 	"github.com/dave/jsgo/server/frizz/gotypes"
 	"github.com/dave/jsgo/server/frizz/gotypes/convert"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/s3"
 	"github.com/dave/services"
 	"github.com/dave/services/builder"
 	"github.com/dave/services/constor"
@@ -52,73 +53,87 @@ func init() {
 
 func main() {
 
+	if err := logger.Init(); err != nil {
+		logger.Default.Fatal("configuring logger", "error", err)
+	}
+
+	var frizzEnabled bool
+	flag.BoolVar(&frizzEnabled, "frizz", false, "Enable frizz mode")
+	configFile := flag.String("config", os.Getenv("JSGO_CONFIG"), "JSON config file")
+	preset := flag.String("preset", "", "config preset (prod, dev or local)")
+	flag.Parse()
+
+	c, err := config.Load(*preset, *configFile)
+	if err != nil {
+		logger.Default.Fatal("loading config", "error", err)
+	}
+	config.Apply(c)
+
 	ctx := context.Background()
 
 	var fileserver services.Fileserver
-	if config.LOCAL {
+	if objects := s3.FromEnv(); objects != nil {
+		fileserver = s3.NewFileserver(objects)
+	} else if config.LOCAL {
 		fileserver = localfileserver.New(config.LocalFileserverTempDir, nil, nil, nil)
 	} else {
 		client, err := storage.NewClient(ctx)
 		if err != nil {
-			log.Fatal(err)
+			logger.Default.Fatal("initialise failed", "error", err)
 		}
 		defer client.Close()
 		fileserver = gcsfileserver.New(client, config.Buckets)
 	}
 
-	var frizzEnabled bool
-	flag.BoolVar(&frizzEnabled, "frizz", false, "Enable frizz mode")
-	flag.Parse()
-
 	storer := constor.New(ctx, fileserver, nil, 20)
 
 	archives := map[string]map[bool]*compiler.Archive{}
 	packages, err := getStandardLibraryPackages()
 	if err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 	root, err := getRootFilesystem()
 	if err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 
 	if frizzEnabled {
 		// Creates .json files for the source code of all standard library packages (FRIZZ ONLY)
 		if err := StoreSource(ctx, storer, packages, root); err != nil {
-			log.Fatal(err)
+			logger.Default.Fatal("initialise failed", "error", err)
 		}
 
 		// Creates .objects.gob files for the types of all standard library packages (FRIZZ ONLY)
 		if err := ScanAndStoreTypes(ctx, storer, packages, root); err != nil {
-			log.Fatal(err)
+			logger.Default.Fatal("initialise failed", "error", err)
 		}
 	}
 
 	// Creates .js (compiled JS) and .ax (stripped GopherJS archive) files for all standard library package.
 	if err := CompileAndStoreJavascript(ctx, storer, packages, root, archives); err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 
 	// Creates the GopherJS prelude.
 	if err := Prelude(storer); err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 
 	// Bundles the server-side run-time assets (standard library source, /assets/static, archives.gob) into a single compressed file.
 	if err := CreateAssetsZip(storer, root, archives); err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 
 	// Creates the wasm_exec file (WASMGO ONLY)
 	if err := Wasm(storer); err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
 
-	fmt.Println("Waiting for storage operations...")
+	logger.Default.Info("Waiting for storage operations...")
 	if err := storer.Wait(); err != nil {
-		log.Fatal(err)
+		logger.Default.Fatal("initialise failed", "error", err)
 	}
-	fmt.Println("Storage operations finished.")
+	logger.Default.Info("Storage operations finished.")
 
 }
 
@@ -187,7 +202,7 @@ func StoreSource(ctx context.Context, storer *constor.Storer, packages []string,
 		index[path] = hash
 	}
 
-	fmt.Println("Saving source index...")
+	logger.Default.Info("Saving source index...")
 	/*
 		var Source = map[string]string{
 			"<path>": "<hash>",
@@ -202,12 +217,12 @@ func StoreSource(ctx context.Context, storer *constor.Storer, packages []string,
 	if err := f.Save("../assets/std/source.go"); err != nil {
 		return err
 	}
-	fmt.Println("Done.")
+	logger.Default.Info("Done.")
 	return nil
 }
 
 func ScanAndStoreTypes(ctx context.Context, storer *constor.Storer, stdPackages []string, root billy.Filesystem) error {
-	fmt.Println("Scanning for objects...")
+	logger.Default.Info("Scanning for objects...")
 
 	s := session.New([]string{}, root, nil, nil, config.ValidExtensions)
 
@@ -318,11 +333,11 @@ func ScanAndStoreTypes(ctx context.Context, storer *constor.Storer, stdPackages
 			Count:     true,
 			Send:      true,
 		})
-		fmt.Printf("%s: %d objects\n", path, len(objects))
+		logger.Default.Info("Scanned", "path", path, "objects", len(objects))
 
 	}
 
-	fmt.Println("Saving index...")
+	logger.Default.Info("Saving index...")
 	/*
 		var Objects = map[string]string{
 			"<path>": "<hash>",
@@ -339,12 +354,12 @@ func ScanAndStoreTypes(ctx context.Context, storer *constor.Storer, stdPackages
 		return err
 	}
 
-	fmt.Println("Done")
+	logger.Default.Info("Done")
 	return nil
 }
 
 func CompileAndStoreJavascript(ctx context.Context, storer *constor.Storer, packages []string, root billy.Filesystem, archives map[string]map[bool]*compiler.Archive) error {
-	fmt.Println("Loading...")
+	logger.Default.Info("Loading...")
 
 	s := session.New(nil, root, nil, nil, nil)
 
@@ -360,7 +375,7 @@ func CompileAndStoreJavascript(ctx context.Context, storer *constor.Storer, pack
 
 		sent := map[string]bool{}
 		for _, p := range packages {
-			fmt.Println("Compiling:", p+minified)
+			logger.Default.Info("Compiling", "path", p+minified)
 			if _, _, err := b.BuildImportPath(ctx, p); err != nil {
 				return err
 			}
@@ -370,7 +385,7 @@ func CompileAndStoreJavascript(ctx context.Context, storer *constor.Storer, pack
 				if sent[path] {
 					continue
 				}
-				fmt.Println("Storing:", path+minified)
+				logger.Default.Info("Storing", "path", path+minified)
 
 				contents, hash, err := builder.GetPackageCode(ctx, archive, min, true)
 				if err != nil {
@@ -440,7 +455,7 @@ func CompileAndStoreJavascript(ctx context.Context, storer *constor.Storer, pack
 		return err
 	}
 
-	fmt.Println("Saving index...")
+	logger.Default.Info("Saving index...")
 	/*
 		var Index = map[string]map[bool]string{
 			{
@@ -462,17 +477,17 @@ func CompileAndStoreJavascript(ctx context.Context, storer *constor.Storer, pack
 	if err := f.Save("../assets/std/index.go"); err != nil {
 		return err
 	}
-	fmt.Println("Done.")
+	logger.Default.Info("Done.")
 
 	return nil
 }
 
 func CreateAssetsZip(storer *constor.Storer, root billy.Filesystem, archives map[string]map[bool]*compiler.Archive) error {
-	fmt.Println("Loading...")
+	logger.Default.Info("Loading...")
 	buf := new(bytes.Buffer)
 	w := zip.NewWriter(buf)
 
-	fmt.Println("Zipping...")
+	logger.Default.Info("Zipping...")
 	var compress func(billy.Filesystem, string) error
 	compress = func(fs billy.Filesystem, dir string) error {
 
@@ -0,0 +1,284 @@
+// Package admin serves the dashboard at /_admin/ and its JSON API at /_admin/api/, which list the
+// recent compiles, deploys, shares and errors stored in the database.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/store"
+	"github.com/dustin/go-humanize"
+)
+
+// Handler serves the dashboard and the API. Requests must authenticate with the token, either as a
+// bearer token or as the password of basic auth (so the dashboard can be opened in a browser). The
+// dashboard is disabled if the token is empty.
+type Handler struct {
+	Querier store.Querier
+	Token   string
+}
+
+// query is an API endpoint.
+type query func(ctx context.Context, querier store.Querier, f store.Filter) (interface{}, error)
+
+var queries = map[string]query{
+	"compiles": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.RecentCompiles(ctx, q, f)
+	},
+	"deploys": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.RecentDeploys(ctx, q, f)
+	},
+	"shares": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.RecentShares(ctx, q, f)
+	},
+	"wasm-deploys": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.RecentWasmDeploys(ctx, q, f)
+	},
+	"errors": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.RecentErrors(ctx, q, f)
+	},
+	"error-frequencies": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.ErrorFrequencies(ctx, q, f)
+	},
+	"top-packages": func(ctx context.Context, q store.Querier, f store.Filter) (interface{}, error) {
+		return store.TopPackages(ctx, q, f)
+	},
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.allow(w, req) {
+		return
+	}
+
+	f, err := filter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), config.PageTimeout)
+	defer cancel()
+
+	switch {
+	case req.URL.Path == "/_admin/":
+		h.page(ctx, w, f)
+	case strings.HasPrefix(req.URL.Path, "/_admin/api/"):
+		q, ok := queries[strings.TrimPrefix(req.URL.Path, "/_admin/api/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		h.api(ctx, w, q, f)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// Protect serves next only to requests authenticated with the token, like the dashboard. It's used for
+// /metrics, which shouldn't be public either.
+func (h *Handler) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if h.allow(w, req) {
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// allow reports whether the request is authenticated, and responds if it isn't.
+func (h *Handler) allow(w http.ResponseWriter, req *http.Request) bool {
+	if h.Token == "" {
+		http.NotFound(w, req)
+		return false
+	}
+	if !h.authenticated(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsgo admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) authenticated(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := req.BasicAuth(); ok {
+		token = password
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+// filter reads the filter from the query string: since (a duration, e.g. "1h", or an RFC 3339 time),
+// path, ip and limit.
+func filter(req *http.Request) (store.Filter, error) {
+	q := req.URL.Query()
+	f := store.Filter{
+		Since: time.Now().Add(-config.AdminSince),
+		Path:  q.Get("path"),
+		Ip:    q.Get("ip"),
+		Limit: config.AdminPageSize,
+	}
+	if s := q.Get("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			f.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			f.Since = t
+		} else {
+			return store.Filter{}, err
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return store.Filter{}, err
+		}
+		f.Limit = limit
+	}
+	return f, nil
+}
+
+func (h *Handler) api(ctx context.Context, w http.ResponseWriter, q query, f store.Filter) {
+	result, err := q(ctx, h.Querier, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v := reflect.ValueOf(result); v.Kind() == reflect.Slice && v.IsNil() {
+		// Encode empty results as [] rather than null.
+		result = []struct{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) page(ctx context.Context, w http.ResponseWriter, f store.Filter) {
+
+	type vars struct {
+		Since       string
+		Path        string
+		Ip          string
+		Packages    []store.Frequency
+		Frequencies []store.Frequency
+		Compiles    []store.CompileRecord
+		Deploys     []store.DeployData
+		Shares      []store.ShareData
+		WasmDeploys []store.WasmDeploy
+		Errors      []store.Error
+	}
+
+	v := vars{Since: humanize.Time(f.Since), Path: f.Path, Ip: f.Ip}
+	var err error
+	if v.Packages, err = store.TopPackages(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.Frequencies, err = store.ErrorFrequencies(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.Compiles, err = store.RecentCompiles(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.Deploys, err = store.RecentDeploys(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.Shares, err = store.RecentShares(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.WasmDeploys, err = store.RecentWasmDeploys(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v.Errors, err = store.RecentErrors(ctx, h.Querier, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := pageTemplate.Execute(w, v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+var pageTemplate = template.Must(template.New("main").Funcs(template.FuncMap{"Time": humanize.Time}).Parse(`
+<html>
+	<head>
+		<meta charset="utf-8">
+		<title>jsgo admin</title>
+		<style>
+			body { font-family: sans-serif; font-size: 14px; margin: 2em; }
+			table { border-collapse: collapse; margin-bottom: 2em; }
+			th, td { text-align: left; padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+			td.error { font-family: monospace; white-space: pre-wrap; max-width: 60em; }
+			.failed { color: #a33; }
+		</style>
+	</head>
+	<body>
+		<h1>jsgo admin</h1>
+		<form method="get" action="/_admin/">
+			since <input name="since" placeholder="24h">
+			path <input name="path" value="{{ .Path }}">
+			ip <input name="ip" value="{{ .Ip }}">
+			<input type="submit" value="Filter">
+		</form>
+		<p>Records since {{ .Since }}{{ if .Path }} matching {{ .Path }}{{ end }}{{ if .Ip }} from {{ .Ip }}{{ end }}.</p>
+
+		<h2>Top packages</h2>
+		<table>
+			<tr><th>Compiles</th><th>Path</th><th>Last</th></tr>
+			{{ range .Packages }}<tr><td>{{ .Count }}</td><td>{{ .Key }}</td><td>{{ Time .Last }}</td></tr>{{ end }}
+		</table>
+
+		<h2>Error frequencies</h2>
+		<table>
+			<tr><th>Count</th><th>Error</th><th>Last</th></tr>
+			{{ range .Frequencies }}<tr><td>{{ .Count }}</td><td class="error">{{ .Key }}</td><td>{{ Time .Last }}</td></tr>{{ end }}
+		</table>
+
+		<h2>Compiles</h2>
+		<table>
+			<tr><th>Time</th><th>Path</th><th>Version</th><th>IP</th><th>Result</th></tr>
+			{{ range .Compiles }}<tr>
+				<td>{{ Time .Time }}</td>
+				<td>{{ .Path }}</td>
+				<td>{{ .Version }}{{ if .Commit }} ({{ .Commit }}){{ end }}</td>
+				<td>{{ .Ip }}</td>
+				<td>{{ if .Success }}ok{{ else }}<span class="failed">failed{{ if .Phase }} ({{ .Phase }}){{ end }}</span>{{ end }}</td>
+			</tr>{{ end }}
+		</table>
+
+		<h2>Deploys</h2>
+		<table>
+			<tr><th>Time</th><th>Main</th><th>Packages</th><th>IP</th></tr>
+			{{ range .Deploys }}<tr><td>{{ Time .Time }}</td><td>{{ .Contents.Main }}</td><td>{{ len .Contents.Packages }}</td><td>{{ .Ip }}</td></tr>{{ end }}
+		</table>
+
+		<h2>Shares</h2>
+		<table>
+			<tr><th>Time</th><th>Hash</th><th>Files</th><th>IP</th></tr>
+			{{ range .Shares }}<tr><td>{{ Time .Time }}</td><td>{{ .Hash }}</td><td>{{ .Files }}</td><td>{{ .Ip }}</td></tr>{{ end }}
+		</table>
+
+		<h2>Wasm deploys</h2>
+		<table>
+			<tr><th>Time</th><th>Files</th><th>IP</th></tr>
+			{{ range .WasmDeploys }}<tr><td>{{ Time .Time }}</td><td>{{ len .Files }}</td><td>{{ .Ip }}</td></tr>{{ end }}
+		</table>
+
+		<h2>Errors</h2>
+		<table>
+			<tr><th>Time</th><th>Error</th><th>IP</th></tr>
+			{{ range .Errors }}<tr><td>{{ Time .Time }}</td><td class="error">{{ .Error }}</td><td>{{ .Ip }}</td></tr>{{ end }}
+		</table>
+	</body>
+</html>
+`))
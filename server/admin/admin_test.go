@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	h := &Handler{}
+	protected := h.Protect(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	get := func(authorization string) int {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Disabled without a token.
+	if code := get("Bearer "); code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a token, got %d", code)
+	}
+
+	h.Token = "secret"
+	for authorization, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		if code := get(authorization); code != expected {
+			t.Errorf("expected %d with %q, got %d", expected, authorization, code)
+		}
+	}
+}
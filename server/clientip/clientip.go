@@ -0,0 +1,28 @@
+// Package clientip identifies the client that made a request.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/dave/jsgo/config"
+)
+
+// Get returns the address of the client, for per-client limits and to check the client that made a
+// compile. The client controls the start of X-Forwarded-For, so we use the entry added by the first
+// of the config.TrustedProxies, falling back to the remote address.
+func Get(req *http.Request) string {
+	if forwarded := req.Header["X-Forwarded-For"]; config.TrustedProxies > 0 && len(forwarded) > 0 {
+		entries := strings.Split(strings.Join(forwarded, ","), ",")
+		i := len(entries) - config.TrustedProxies
+		if i < 0 {
+			i = 0
+		}
+		return strings.TrimSpace(entries[i])
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
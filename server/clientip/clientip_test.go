@@ -0,0 +1,35 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dave/jsgo/config"
+)
+
+func TestGet(t *testing.T) {
+	defer func(n int) { config.TrustedProxies = n }(config.TrustedProxies)
+	for _, test := range []struct {
+		proxies   int
+		forwarded []string
+		expected  string
+	}{
+		{0, []string{"1.1.1.1"}, "10.0.0.1"},
+		{1, nil, "10.0.0.1"},
+		{1, []string{"1.1.1.1"}, "1.1.1.1"},
+		{1, []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{1, []string{"6.6.6.6", "1.1.1.1"}, "1.1.1.1"},
+		{2, []string{"6.6.6.6, 1.1.1.1, 10.0.0.2"}, "1.1.1.1"},
+		{2, []string{"1.1.1.1"}, "1.1.1.1"},
+	} {
+		config.TrustedProxies = test.proxies
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		for _, v := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if found := Get(req); found != test.expected {
+			t.Errorf("%d %v: got %s, expected %s", test.proxies, test.forwarded, found, test.expected)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package codec encodes the messages sent over the websocket connections. Each handler has a Registry
+// of its message types, and the format is chosen for each connection with the websocket subprotocol.
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/gorilla/websocket"
+)
+
+// Websocket subprotocols.
+const (
+	Json    = "json"     // {"Type": "<type name>", "ID": "<command ID>", "Message": {...}}
+	Gob     = "gob"      // Payload, gob encoded
+	GzipGob = "gzip-gob" // Payload, gob encoded and gzipped
+	Msgpack = "msgpack"  // Type name, message and command ID (see the msgpack package)
+)
+
+// Codec is a message format.
+type Codec interface {
+	Marshal(r *Registry, in services.Message) (payload []byte, messageType int, err error)
+	Unmarshal(r *Registry, in []byte) (services.Message, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{
+		Json:    jsonCodec{},
+		Gob:     gobCodec{},
+		GzipGob: gobCodec{gzip: true},
+	},
+}
+
+// Register makes a codec available to all registries, for codecs with dependencies that shouldn't be
+// imported by the clients (see the msgpack package).
+func Register(protocol string, c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[protocol] = c
+}
+
+// Registry is the message types of a handler.
+type Registry struct {
+	protocol string
+	types    map[string]reflect.Type
+	untyped  reflect.Type
+}
+
+// NewRegistry creates a registry of message types. The protocol is used if the client doesn't request
+// one. The types are also registered with gob.
+func NewRegistry(protocol string, messages ...services.Message) *Registry {
+	r := &Registry{
+		protocol: protocol,
+		types:    map[string]reflect.Type{},
+	}
+	for _, m := range messages {
+		t := reflect.TypeOf(m)
+		r.types[t.Name()] = t
+		gob.Register(m)
+	}
+	return r
+}
+
+// Untyped sets the type of JSON messages with a missing or unknown Type (older jsgo clients didn't set
+// the Type of the Compile message).
+func (r *Registry) Untyped(m services.Message) *Registry {
+	r.untyped = reflect.TypeOf(m)
+	return r
+}
+
+// Protocols returns the websocket subprotocols, with the default first. Each codec is offered bare and
+// with each of config.ProtocolVersions (e.g. "json" and "json.v1"), so a client can check the server
+// supports its version of the messages.
+func (r *Registry) Protocols() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	var names []string
+	for protocol := range codecs.m {
+		if protocol != r.protocol {
+			names = append(names, protocol)
+		}
+	}
+	sort.Strings(names)
+	var protocols []string
+	for _, name := range append([]string{r.protocol}, names...) {
+		protocols = append(protocols, name)
+		for _, version := range config.ProtocolVersions {
+			protocols = append(protocols, name+".v"+version)
+		}
+	}
+	return protocols
+}
+
+// Type returns the message type with the specified name.
+func (r *Registry) Type(name string) (reflect.Type, bool) {
+	t, ok := r.types[name]
+	return t, ok
+}
+
+// Version returns the version of the messages requested with the subprotocol (e.g. "1" for "json.v1"),
+// or an empty string for a bare codec.
+func Version(protocol string) string {
+	if i := strings.LastIndex(protocol, ".v"); i > -1 {
+		return protocol[i+len(".v"):]
+	}
+	return ""
+}
+
+func (r *Registry) codec(protocol string) (Codec, error) {
+	if protocol == "" {
+		protocol = r.protocol
+	}
+	if i := strings.LastIndex(protocol, ".v"); i > -1 {
+		protocol = protocol[:i]
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[protocol]
+	if !ok {
+		return nil, fmt.Errorf("protocol %s not supported", protocol)
+	}
+	return c, nil
+}
+
+// Marshal encodes a message with the codec for the protocol, or the default codec if protocol is empty.
+func (r *Registry) Marshal(protocol string, in services.Message) ([]byte, int, error) {
+	c, err := r.codec(protocol)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.Marshal(r, in)
+}
+
+// Unmarshal decodes a message with the codec for the protocol, or the default codec if protocol is
+// empty.
+func (r *Registry) Unmarshal(protocol string, in []byte) (services.Message, error) {
+	c, err := r.codec(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return c.Unmarshal(r, in)
+}
+
+// Split returns the ID and the wrapped message of a servermsg.Reply or servermsg.Command. Other
+// messages have no ID.
+func Split(in services.Message) (id string, message services.Message) {
+	switch in := in.(type) {
+	case servermsg.Reply:
+		return in.ID, in.Message
+	case servermsg.Command:
+		return in.ID, in.Message
+	}
+	return "", in
+}
+
+// Join wraps a message from the client in a servermsg.Command if it has an ID.
+func Join(id string, message services.Message) services.Message {
+	if id == "" {
+		return message
+	}
+	return servermsg.Command{ID: id, Message: message}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(r *Registry, in services.Message) ([]byte, int, error) {
+	id, in := Split(in)
+	m := struct {
+		Type    string
+		ID      string `json:",omitempty"`
+		Message services.Message
+	}{
+		Type:    reflect.TypeOf(in).Name(),
+		ID:      id,
+		Message: in,
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, websocket.TextMessage, nil
+}
+
+func (jsonCodec) Unmarshal(r *Registry, in []byte) (services.Message, error) {
+	var m struct {
+		Type    string
+		ID      string
+		Message json.RawMessage
+	}
+	if err := json.Unmarshal(in, &m); err != nil {
+		return nil, err
+	}
+	typ, ok := r.types[m.Type]
+	if !ok && r.untyped != nil {
+		typ, ok = r.untyped, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("type not found: %s", m.Type)
+	}
+	pointer := reflect.New(typ)
+	if len(m.Message) > 0 {
+		if err := json.Unmarshal(m.Message, pointer.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return Join(m.ID, pointer.Elem().Interface()), nil
+}
+
+// Payload wraps messages in the gob codecs, so the type is encoded.
+type Payload struct {
+	Message services.Message
+	ID      string // Command ID in a session
+}
+
+type gobCodec struct {
+	gzip bool
+}
+
+func (c gobCodec) Marshal(r *Registry, in services.Message) ([]byte, int, error) {
+	id, in := Split(in)
+	p := Payload{Message: in, ID: id}
+	buf := &bytes.Buffer{}
+	if !c.gzip {
+		if err := gob.NewEncoder(buf).Encode(p); err != nil {
+			return nil, 0, err
+		}
+		return buf.Bytes(), websocket.BinaryMessage, nil
+	}
+	gzw := gzip.NewWriter(buf)
+	if err := gob.NewEncoder(gzw).Encode(p); err != nil {
+		return nil, 0, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), websocket.BinaryMessage, nil
+}
+
+func (c gobCodec) Unmarshal(r *Registry, in []byte) (services.Message, error) {
+	var p Payload
+	if !c.gzip {
+		if err := gob.NewDecoder(bytes.NewBuffer(in)).Decode(&p); err != nil {
+			return nil, err
+		}
+		return Join(p.ID, p.Message), nil
+	}
+	gzr, err := gzip.NewReader(bytes.NewBuffer(in))
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(gzr).Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := gzr.Close(); err != nil {
+		return nil, err
+	}
+	return Join(p.ID, p.Message), nil
+}
@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+)
+
+type testMessage struct {
+	Path  string
+	Count int
+}
+
+type testCompile struct {
+	Path string
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := NewRegistry(Json, testMessage{})
+	message := testMessage{Path: "a", Count: 1}
+	for _, protocol := range []string{"", Json, Gob, GzipGob, "json.v1", "gzip-gob.v1"} {
+		for _, test := range []struct {
+			in, out services.Message
+		}{
+			{message, message},
+			// Replies are sent with the ID in the envelope, and decoded as commands.
+			{servermsg.Reply{ID: "1", Message: message}, servermsg.Command{ID: "1", Message: message}},
+		} {
+			b, _, err := r.Marshal(protocol, test.in)
+			if err != nil {
+				t.Fatalf("%q: %v", protocol, err)
+			}
+			out, err := r.Unmarshal(protocol, b)
+			if err != nil {
+				t.Fatalf("%q: %v", protocol, err)
+			}
+			if !reflect.DeepEqual(out, test.out) {
+				t.Errorf("%q: got %#v, expected %#v", protocol, out, test.out)
+			}
+		}
+	}
+	if _, _, err := r.Marshal("xml", message); err == nil {
+		t.Fatal("expected an error for an unknown protocol")
+	}
+}
+
+func TestUntyped(t *testing.T) {
+	r := NewRegistry(Json, testMessage{}).Untyped(testCompile{})
+	out, err := r.Unmarshal(Json, []byte(`{"Message": {"Path": "a"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != (testCompile{Path: "a"}) {
+		t.Fatalf("unexpected message %#v", out)
+	}
+	if _, err := NewRegistry(Json).Unmarshal(Json, []byte(`{"Type": "testCompile"}`)); err == nil {
+		t.Fatal("expected an error for an unknown type")
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	message := testMessage{Path: "a"}
+	for _, test := range []struct {
+		in      services.Message
+		id      string
+		message services.Message
+	}{
+		{message, "", message},
+		{servermsg.Reply{ID: "1", Message: message}, "1", message},
+		{servermsg.Command{ID: "2", Message: message}, "2", message},
+	} {
+		id, m := Split(test.in)
+		if id != test.id || m != test.message {
+			t.Errorf("Split(%#v): got %q, %#v", test.in, id, m)
+		}
+	}
+	if m := Join("", message); m != message {
+		t.Errorf("Join without an ID: got %#v", m)
+	}
+	if m := Join("1", message); m != (servermsg.Command{ID: "1", Message: message}) {
+		t.Errorf("Join with an ID: got %#v", m)
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	protocols := NewRegistry(Gob).Protocols()
+	if len(protocols) < 2 || protocols[0] != Gob || protocols[1] != Gob+".v1" {
+		t.Fatalf("expected the default first, got %v", protocols)
+	}
+}
@@ -0,0 +1,49 @@
+// Package msgpack registers the msgpack codec. It's kept separate from the codec package so the
+// clients that import the messages packages don't depend on the msgpack library.
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/services"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+)
+
+func init() {
+	codec.Register(codec.Msgpack, msgpackCodec{})
+}
+
+// msgpackCodec encodes the type name, the message and the command ID (in a session).
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(r *codec.Registry, in services.Message) ([]byte, int, error) {
+	id, in := codec.Split(in)
+	buf := &bytes.Buffer{}
+	if err := msgpack.NewEncoder(buf).EncodeMulti(reflect.TypeOf(in).Name(), in, id); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), websocket.BinaryMessage, nil
+}
+
+func (msgpackCodec) Unmarshal(r *codec.Registry, in []byte) (services.Message, error) {
+	d := msgpack.NewDecoder(bytes.NewBuffer(in))
+	name, err := d.DecodeString()
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := r.Type(name)
+	if !ok {
+		return nil, fmt.Errorf("type not found: %s", name)
+	}
+	pointer := reflect.New(typ)
+	if err := d.Decode(pointer.Interface()); err != nil {
+		return nil, err
+	}
+	// The ID is optional outside of sessions.
+	id, _ := d.DecodeString()
+	return codec.Join(id, pointer.Elem().Interface()), nil
+}
@@ -0,0 +1,38 @@
+package msgpack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+)
+
+type testMessage struct {
+	Path  string
+	Count int
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := codec.NewRegistry(codec.Json, testMessage{})
+	message := testMessage{Path: "a", Count: 1}
+	for _, test := range []struct {
+		in, out services.Message
+	}{
+		{message, message},
+		{servermsg.Reply{ID: "1", Message: message}, servermsg.Command{ID: "1", Message: message}},
+	} {
+		b, _, err := r.Marshal(codec.Msgpack, test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := r.Unmarshal(codec.Msgpack, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("got %#v, expected %#v", out, test.out)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package dsstore adapts services.Database, which uses cloud datastore keys and errors, to
+// store.Database, and queries the cloud datastore for the admin pages.
+package dsstore
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"github.com/dave/jsgo/server/store"
+	"github.com/dave/services"
+	"github.com/dave/services/database/gcsdatabase"
+)
+
+// New adapts the database to store.Database.
+func New(database services.Database) store.Database {
+	return &adapter{database: database}
+}
+
+type adapter struct {
+	database services.Database
+}
+
+// DatastoreKey converts a key to a datastore key.
+func DatastoreKey(key store.Key) *datastore.Key {
+	switch {
+	case key.Name != "":
+		return datastore.NameKey(key.Kind, key.Name, nil)
+	case key.ID != 0:
+		return datastore.IDKey(key.Kind, key.ID, nil)
+	}
+	return datastore.IncompleteKey(key.Kind, nil)
+}
+
+// FromDatastoreKey converts a datastore key to a key. Parent keys aren't supported.
+func FromDatastoreKey(key *datastore.Key) store.Key {
+	return store.Key{Kind: key.Kind, Name: key.Name, ID: key.ID}
+}
+
+func (a *adapter) Get(ctx context.Context, key store.Key, dst interface{}) error {
+	return fromDatastoreError(a.database.Get(ctx, DatastoreKey(key), dst))
+}
+
+func (a *adapter) Put(ctx context.Context, key store.Key, src interface{}) (store.Key, error) {
+	k, err := a.database.Put(ctx, DatastoreKey(key), src)
+	if err != nil {
+		return store.Key{}, err
+	}
+	return FromDatastoreKey(k), nil
+}
+
+func (a *adapter) GetMulti(ctx context.Context, keys []store.Key, dst interface{}) ([]bool, error) {
+	dkeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		dkeys[i] = DatastoreKey(key)
+	}
+	found := make([]bool, len(keys))
+	err := a.database.GetMulti(ctx, dkeys, dst)
+	if multi, ok := err.(datastore.MultiError); ok {
+		for i, err := range multi {
+			switch err {
+			case nil:
+				found[i] = true
+			case datastore.ErrNoSuchEntity:
+			default:
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+	if err != nil {
+		return nil, fromDatastoreError(err)
+	}
+	for i := range found {
+		found[i] = true
+	}
+	return found, nil
+}
+
+func fromDatastoreError(err error) error {
+	if err == datastore.ErrNoSuchEntity {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// NewGcsQuerier queries the cloud datastore used by gcsdatabase.
+func NewGcsQuerier(database *gcsdatabase.Database) store.Querier {
+	return &gcsQuerier{database: database}
+}
+
+type gcsQuerier struct {
+	database *gcsdatabase.Database
+}
+
+// Recent needs a composite index on Ip and Time for each kind (see index.yaml) to filter by IP.
+func (q *gcsQuerier) Recent(ctx context.Context, kind string, query store.Query, dst interface{}) ([]store.Key, error) {
+	dq := datastore.NewQuery(kind).Filter("Time >=", query.Since).Order("-Time").Limit(query.Limit)
+	if !query.Until.IsZero() {
+		dq = dq.Filter("Time <=", query.Until)
+	}
+	if query.Ip != "" {
+		dq = dq.Filter("Ip =", query.Ip)
+	}
+	dkeys, err := q.database.GetAll(ctx, dq, dst)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]store.Key, len(dkeys))
+	for i, k := range dkeys {
+		keys[i] = FromDatastoreKey(k)
+	}
+	return keys, nil
+}
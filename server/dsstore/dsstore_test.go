@@ -0,0 +1,100 @@
+package dsstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/server/dsstore"
+	"github.com/dave/jsgo/server/store"
+	"github.com/dave/services/database/localdatabase"
+)
+
+func TestLocalQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	database := dsstore.New(localdatabase.New(dir))
+	querier := store.NewLocalQuerier(dir)
+	now := time.Now()
+
+	compiles := []store.CompileData{
+		{Path: "github.com/a/a", Ip: "1.1.1.1", Time: now.Add(-time.Minute * 3), Success: true},
+		{Path: "github.com/a/a", Ip: "2.2.2.2", Time: now.Add(-time.Minute * 2), Success: true},
+		{Path: "github.com/b/b", Ip: "1.1.1.1", Time: now.Add(-time.Minute), Success: false},
+		{Path: "github.com/c/c", Ip: "1.1.1.1", Time: now.Add(-time.Hour * 48), Success: true},
+	}
+	for _, c := range compiles {
+		if err := store.StoreCompile(ctx, database, c.Path, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []store.Error{
+		{Error: "a failed\ndetails", Ip: "1.1.1.1", Time: now.Add(-time.Minute)},
+		{Error: "a failed\nother details", Ip: "1.1.1.1", Time: now.Add(-time.Minute * 2)},
+		{Error: "b failed", Ip: "2.2.2.2", Time: now.Add(-time.Minute * 3)},
+	} {
+		if err := store.StoreError(ctx, database, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := now.Add(-time.Hour)
+
+	records, err := store.RecentCompiles(ctx, querier, store.Filter{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Path != "github.com/b/b" || records[2].Path != "github.com/a/a" {
+		t.Fatalf("unexpected compiles %#v", records)
+	}
+	if found, data, err := store.Compile(ctx, database, records[0].ID); err != nil || !found || data.Path != "github.com/b/b" {
+		t.Fatalf("compile %d not found by ID (%v)", records[0].ID, err)
+	}
+
+	records, err = store.RecentCompiles(ctx, querier, store.Filter{Since: since, Ip: "1.1.1.1", Path: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Ip != "1.1.1.1" || records[0].Path != "github.com/a/a" {
+		t.Fatalf("unexpected filtered compiles %#v", records)
+	}
+
+	records, err = store.RecentCompiles(ctx, querier, store.Filter{Since: since, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 compile, got %d", len(records))
+	}
+
+	top, err := store.TopPackages(ctx, querier, store.Filter{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].Key != "github.com/a/a" || top[0].Count != 2 || !top[0].Last.Equal(compiles[1].Time) {
+		t.Fatalf("unexpected top packages %#v", top)
+	}
+
+	frequencies, err := store.ErrorFrequencies(ctx, querier, store.Filter{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frequencies) != 2 || frequencies[0].Key != "a failed" || frequencies[0].Count != 2 || frequencies[1].Key != "b failed" {
+		t.Fatalf("unexpected error frequencies %#v", frequencies)
+	}
+
+	shares, err := store.RecentShares(ctx, querier, store.Filter{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 0 {
+		t.Fatalf("expected no shares, got %d", len(shares))
+	}
+}
@@ -1,32 +1,33 @@
 package messages
 
 import (
-	"bytes"
-	"encoding/gob"
-
+	"github.com/dave/jsgo/server/codec"
 	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
 	"github.com/dave/services/builder/buildermsg"
 	"github.com/dave/services/constor/constormsg"
 	"github.com/dave/services/deployer/deployermsg"
 	"github.com/dave/services/getter/gettermsg"
-	"github.com/gorilla/websocket"
 )
 
-type Payload struct {
-	Message services.Message
-}
+// Payload wraps the gob encoded messages.
+type Payload = codec.Payload
 
-func init() {
+// Codecs encodes the messages. Gob is the default.
+var Codecs = codec.NewRegistry(
+	codec.Gob,
 
 	// Commands:
-	gob.Register(GetPackages{})
+	servermsg.Cancel{},
+	GetPackages{},
 
 	// Data messages:
-	gob.Register(PackageIndex{})
-	gob.Register(Source{})
-	gob.Register(Objects{})
+	PackageIndex{},
+	Source{},
+	Objects{},
+)
 
+func init() {
 	// Initialise types in deployermsg
 	deployermsg.RegisterTypes()
 
@@ -82,18 +83,9 @@ type Objects struct {
 }
 
 func Marshal(in services.Message) ([]byte, int, error) {
-	p := Payload{in}
-	buf := &bytes.Buffer{}
-	if err := gob.NewEncoder(buf).Encode(p); err != nil {
-		return nil, 0, err
-	}
-	return buf.Bytes(), websocket.BinaryMessage, nil
+	return Codecs.Marshal(codec.Gob, in)
 }
 
 func Unmarshal(in []byte) (services.Message, error) {
-	var p Payload
-	if err := gob.NewDecoder(bytes.NewBuffer(in)).Decode(&p); err != nil {
-		return nil, err
-	}
-	return p.Message, nil
+	return Codecs.Unmarshal(codec.Gob, in)
 }
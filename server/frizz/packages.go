@@ -27,6 +27,7 @@ import (
 	"github.com/dave/jsgo/server/frizz/gotypes"
 	"github.com/dave/jsgo/server/frizz/gotypes/convert"
 	"github.com/dave/jsgo/server/frizz/messages"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
 	"github.com/dave/services/constor"
 	"github.com/dave/services/getter/get"
@@ -57,7 +58,7 @@ func (h *Handler) Packages(ctx context.Context, info messages.GetPackages, req *
 
 	gitreq := h.Cache.NewRequest(save)
 	if err := gitreq.InitialiseFromHints(ctx, info.Path); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, info.Path, err)
 	}
 
 	// set insecure = true in local mode or it will fail if git repo has git protocol
@@ -153,7 +154,7 @@ func (h *Handler) Packages(ctx context.Context, info messages.GetPackages, req *
 	}
 
 	if err := g.Get(ctx, info.Path, false, insecure, false); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, info.Path, err)
 	}
 
 	// Parse for types
@@ -175,14 +176,14 @@ func (h *Handler) Packages(ctx context.Context, info messages.GetPackages, req *
 		}
 		match, err := bctx.MatchFile(filepath.Join(bctx.GOPATH, "src", info.Path), f.name)
 		if err != nil {
-			return err
+			return servermsg.Failed(servermsg.PhaseBuild, info.Path, err)
 		}
 		if !match {
 			continue
 		}
 		astfile, err := parser.ParseFile(fset, filepath.Join(bctx.GOPATH, "src", info.Path, f.name), []byte(f.contents), 0)
 		if err != nil {
-			return err
+			return servermsg.Failed(servermsg.PhaseBuild, info.Path, err)
 		}
 		parsed = append(parsed, astfile)
 	}
@@ -260,7 +261,7 @@ func (h *Handler) Packages(ctx context.Context, info messages.GetPackages, req *
 			buf := &bytes.Buffer{}
 			mw := io.MultiWriter(sha, buf)
 			if err := stablegob.NewEncoder(mw).Encode(pp); err != nil {
-				return err
+				return servermsg.Failed(servermsg.PhaseBuild, p.Path(), err)
 			}
 			hash = fmt.Sprintf("%x", sha.Sum(nil))
 			if cached, ok := info.Objects[p.Path()]; ok && cached == hash {
@@ -295,11 +296,11 @@ func (h *Handler) Packages(ctx context.Context, info messages.GetPackages, req *
 	}
 
 	if err := storer.Wait(); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, info.Path, err)
 	}
 
 	if err := gitreq.Close(ctx); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, info.Path, err)
 	}
 
 	send(index)
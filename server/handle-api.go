@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/jsgo/server/jsgo/messages"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+)
+
+type streamType int
+
+const (
+	streamNone streamType = iota
+	streamNdjson
+	streamSse
+)
+
+// getStream chooses the response format for the compile API. The "stream" query parameter takes
+// precedence over the Accept header.
+func getStream(req *http.Request) streamType {
+	switch req.URL.Query().Get("stream") {
+	case "ndjson":
+		return streamNdjson
+	case "sse":
+		return streamSse
+	}
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return streamNdjson
+	case strings.Contains(accept, "text/event-stream"):
+		return streamSse
+	}
+	return streamNone
+}
+
+// CompileApiHandler exposes the jsgo compile pipeline as a plain HTTP endpoint. The request body is a
+// JSON encoded messages.Compile. By default the response is the messages.Complete as JSON. If
+// streaming is requested (with ?stream=ndjson, ?stream=sse or the equivalent Accept header) all
+// progress messages are sent as newline-delimited JSON or server-sent events.
+func (h *Handler) CompileApiHandler(s SocketHandlerInterface) func(w http.ResponseWriter, req *http.Request) {
+
+	return func(w http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !h.Origins.Allowed(s.Lane(), req) {
+			writeApiError(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+
+		h.Waitgroup.Add(1)
+		defer func() {
+			h.Waitgroup.Done()
+		}()
+
+		tj := tracker.Default.Start()
+		defer func() {
+			tj.End()
+		}()
+
+		ctx, cancel := context.WithTimeout(req.Context(), s.RequestTimeout())
+		defer func() {
+			cancel()
+		}()
+
+		var info messages.Compile
+		if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
+			writeApiError(w, http.StatusBadRequest, fmt.Sprintf("decoding request: %v", err))
+			return
+		}
+		if info.Path == "" {
+			writeApiError(w, http.StatusBadRequest, "path must be specified")
+			return
+		}
+
+		stream := getStream(req)
+		flusher, _ := w.(http.Flusher)
+
+		switch stream {
+		case streamNdjson:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		case streamSse:
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		if stream != streamNone {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		var m sync.Mutex
+		var finished bool
+		var complete *messages.Complete
+		var failure *servermsg.Error
+
+		send := func(message services.Message) {
+			m.Lock()
+			defer m.Unlock()
+			if finished {
+				return // prevent more messages from being written after the handler has returned
+			}
+			switch message := message.(type) {
+			case messages.Complete:
+				complete = &message
+			case servermsg.Error:
+				if failure == nil {
+					failure = &message
+				}
+			}
+			if stream == streamNone {
+				return
+			}
+			b, _, err := s.MarshalMessage(codec.Json, message)
+			if err != nil {
+				return
+			}
+			switch stream {
+			case streamNdjson:
+				w.Write(b)
+				w.Write([]byte("\n"))
+			case streamSse:
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", reflect.TypeOf(message).Name(), b)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		// The handler reads the instruction from the receive channel, just as it would for a websocket.
+		receive := make(chan services.Message, 1)
+		receive <- info
+
+		h.run(ctx, cancel, req, s, send, receive, tj)
+
+		m.Lock()
+		defer m.Unlock()
+		finished = true
+
+		if stream != streamNone {
+			return
+		}
+
+		switch {
+		case complete != nil:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(complete)
+		case failure != nil:
+			if failure.RetryAfter > 0 {
+				w.Header().Set("Retry-After", fmt.Sprint(failure.RetryAfter))
+			}
+			writeApiFailure(w, apiStatus(failure.Code), *failure)
+		default:
+			writeApiError(w, http.StatusInternalServerError, "compile did not complete")
+		}
+	}
+}
+
+// apiStatus returns the HTTP status for an error code.
+func apiStatus(code string) int {
+	switch code {
+	case servermsg.CodeRateLimited:
+		return http.StatusTooManyRequests
+	case servermsg.CodeQueueFull, servermsg.CodeShutdown, servermsg.CodeDraining:
+		return http.StatusServiceUnavailable
+	case servermsg.CodeTimeout:
+		return http.StatusGatewayTimeout
+	case servermsg.CodeInvalidRequest:
+		return http.StatusBadRequest
+	case servermsg.CodeTooManyGitObjects, servermsg.CodeUnrecognizedImportPath, servermsg.CodePackageNotFound,
+		servermsg.CodeNoGoFiles, servermsg.CodeChecksumMismatch, servermsg.CodeCompileError:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	code := servermsg.CodeInternal
+	if status < 500 {
+		code = servermsg.CodeInvalidRequest
+	}
+	writeApiFailure(w, status, servermsg.Error{Message: message, Code: code})
+}
+
+func writeApiFailure(w http.ResponseWriter, status int, failure servermsg.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(failure)
+}
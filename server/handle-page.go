@@ -2,14 +2,15 @@ package server
 
 import (
 	"net/http"
-	"strings"
 
 	"fmt"
 
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/clientip"
 	"github.com/dave/jsgo/server/frizz"
 	"github.com/dave/jsgo/server/jsgo"
 	"github.com/dave/jsgo/server/play"
+	"github.com/dave/jsgo/server/wasm"
 )
 
 type pageType int
@@ -19,33 +20,39 @@ const (
 	PlayPage
 	JsgoPage
 	FrizzPage
+	WasmPage
 )
 
-func getPage(req *http.Request) pageType {
-	if config.DEV {
-		switch {
-		case strings.HasSuffix(req.Host, "8080"):
-			return PlayPage
-		case strings.HasSuffix(req.Host, "8081"):
-			return JsgoPage
-		case strings.HasSuffix(req.Host, "8082"):
-			return FrizzPage
-		}
-	} else {
-		switch req.Host {
-		case "play.jsgo.io":
-			return PlayPage
-		case "compile.jsgo.io":
-			return JsgoPage
-		case "frizz.io":
-			return FrizzPage
-		}
-	}
-	return UnknownPage
+// pageRoutes maps page types to the keys of config.PageRateLimits
+var pageRoutes = map[pageType]string{
+	PlayPage:  config.Play,
+	JsgoPage:  config.Jsgo,
+	FrizzPage: config.Frizz,
+	WasmPage:  config.Wasm,
 }
 
 func (h *Handler) PageHandler(w http.ResponseWriter, req *http.Request) {
-	switch getPage(req) {
+	page, prefix := h.Router.Route(req)
+	req = stripPrefix(req, prefix)
+	if page == JsgoPage && prefix != "/" && (req.URL.Path == "/_jsgo/" || req.URL.Path == "/compile.css") {
+		// The socket and stylesheet of the compile page mounted at a prefix
+		h.mux.ServeHTTP(w, req)
+		return
+	}
+	if route, ok := pageRoutes[page]; ok {
+		if allowed, retry := h.PageLimits.AllowClient(route, clientip.Get(req)); !allowed {
+			e := rateLimited(retry)
+			w.Header().Set("Retry-After", fmt.Sprint(e.RetryAfter))
+			http.Error(w, e.Message, http.StatusTooManyRequests)
+			return
+		}
+	}
+	if req.URL.Path == "/_script.js" || req.URL.Path == "/_script.js.map" {
+		// The dev mode script of a page mounted at a prefix
+		h.script(w, req, page)
+		return
+	}
+	switch page {
 	case PlayPage:
 		play.Page(w, req, h.Database)
 		return
@@ -55,6 +62,9 @@ func (h *Handler) PageHandler(w http.ResponseWriter, req *http.Request) {
 	case FrizzPage:
 		frizz.Page(w, req, h.Database)
 		return
+	case WasmPage:
+		wasm.Page(w, req)
+		return
 	default:
 		http.Error(w, fmt.Sprintf("unknown host %s", req.Host), 500)
 		return
@@ -15,21 +15,27 @@ import (
 )
 
 func (h *Handler) ScriptHandler(w http.ResponseWriter, req *http.Request) {
+	page, prefix := h.Router.Route(req)
+	h.script(w, stripPrefix(req, prefix), page)
+}
+
+// script serves the dev mode script of the page.
+func (h *Handler) script(w http.ResponseWriter, req *http.Request, page pageType) {
 	if !config.DEV {
 		http.Error(w, "script only available in dev mode", 404)
 		return
 	}
-	if err := h.handleScript(w, req); err != nil {
+	if err := h.handleScript(w, req, page); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 }
 
-func (h *Handler) handleScript(w http.ResponseWriter, req *http.Request) error {
+func (h *Handler) handleScript(w http.ResponseWriter, req *http.Request, page pageType) error {
 
 	var path string
 
-	switch getPage(req) {
+	switch page {
 	case PlayPage:
 		path = "github.com/dave/play"
 	case FrizzPage:
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/jsgo/server/sessions"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+)
+
+// session runs the commands sent on a connection opened with ?session, one at a time in the order they
+// were received. Each command is admitted and queued just like the instruction of a single-command
+// connection, so an idle session doesn't hold a worker. Messages with the ID of the running command are
+// passed to it (e.g. the DeployPayload that follows a wasm DeployQuery), or rejected with an error if
+// too many are waiting, except a Cancel which is always delivered. A session.Session is kept warm
+// for the life of the connection (see the sessions package).
+func (h *Handler) session(ctx context.Context, req *http.Request, s SocketHandlerInterface, send func(services.Message), receive chan services.Message) {
+
+	ctx = sessions.NewContext(ctx)
+
+	send(servermsg.Session{})
+
+	var pending []servermsg.Command
+	var running string                 // ID of the running command
+	var forward chan services.Message  // receive channel of the running command
+	var done chan struct{}             // closed when the running command has finished
+	finished := make(chan struct{}, 1) // signals that the running command has finished
+	drained := h.Queue.Draining()      // set to nil once the session has started draining
+
+	start := func(command servermsg.Command) {
+		running = command.ID
+		forward = make(chan services.Message, config.SessionQueueSize)
+		forward <- command.Message
+		done = make(chan struct{})
+		go func(done chan struct{}) {
+			h.command(ctx, req, s, command.ID, send, forward)
+			close(done)
+			finished <- struct{}{}
+		}(done)
+	}
+
+	for {
+		select {
+		case message := <-receive:
+			command, ok := message.(servermsg.Command)
+			if !ok {
+				send(servermsg.NewError(servermsg.Invalid(errors.New("commands in a session must have an ID"))))
+				continue
+			}
+			switch {
+			case command.ID == running && isCancel(command):
+				// A Cancel is never dropped. The command reads its messages until it finishes, so this
+				// only waits while it catches up.
+				select {
+				case forward <- command.Message:
+				case <-done:
+				}
+			case command.ID == running:
+				select {
+				case forward <- command.Message:
+				default:
+					send(servermsg.Reply{ID: command.ID, Message: servermsg.NewError(servermsg.Invalid(errors.New("too many messages waiting for this command")))})
+				}
+			case isCancel(command):
+				// Cancel a command that hasn't started. Commands that have finished are ignored.
+				for i, p := range pending {
+					if p.ID == command.ID {
+						pending = append(pending[:i], pending[i+1:]...)
+						send(servermsg.Reply{ID: command.ID, Message: servermsg.Cancelled{}})
+						send(servermsg.Reply{ID: command.ID, Message: servermsg.Finished{}})
+						break
+					}
+				}
+			case running == "":
+				start(command)
+			case len(pending) >= config.SessionQueueSize:
+				send(servermsg.Reply{ID: command.ID, Message: servermsg.NewError(servermsg.Invalid(errors.New("too many commands waiting in this session")))})
+			default:
+				pending = append(pending, command)
+			}
+		case <-finished:
+			running, forward, done = "", nil, nil
+			if drained == nil {
+				// The server is draining, so close the connection once the running command has finished.
+				return
+			}
+			if len(pending) > 0 {
+				start(pending[0])
+				pending = pending[1:]
+			}
+		case <-drained:
+			// Commands that haven't started are rejected so the client can send them to another instance.
+			for _, command := range pending {
+				send(servermsg.Reply{ID: command.ID, Message: draining()})
+				send(servermsg.Reply{ID: command.ID, Message: servermsg.Finished{}})
+			}
+			pending, drained = nil, nil
+			if running == "" {
+				return
+			}
+		case <-time.After(config.SessionIdleTimeout):
+			if running == "" {
+				return
+			}
+		case <-h.shutdown:
+			// The running command is cancelled by run.
+			if running != "" {
+				<-finished
+			}
+			return
+		case <-ctx.Done():
+			if running != "" {
+				<-finished
+			}
+			return
+		}
+	}
+}
+
+// command runs a single command in a session. All the messages sent are wrapped in a servermsg.Reply
+// with the command ID, and the last is servermsg.Finished.
+func (h *Handler) command(ctx context.Context, req *http.Request, s SocketHandlerInterface, id string, send func(services.Message), receive chan services.Message) {
+	tj := tracker.Default.Start()
+	defer tj.End()
+
+	ctx, cancel := context.WithTimeout(ctx, s.RequestTimeout())
+	defer cancel()
+	ctx = logger.NewContext(ctx, logger.FromContext(ctx, h.Log).With("command", id))
+
+	reply := func(message services.Message) {
+		send(servermsg.Reply{ID: id, Message: message})
+	}
+	h.run(ctx, cancel, req, s, reply, receive, tj)
+	reply(servermsg.Finished{})
+}
+
+func isCancel(command servermsg.Command) bool {
+	_, ok := command.Message.(servermsg.Cancel)
+	return ok
+}
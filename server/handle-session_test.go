@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/gorilla/websocket"
+)
+
+// testSessionHandler is a testSocketHandler that uses the JSON codec, so commands can have IDs.
+type testSessionHandler struct {
+	testSocketHandler
+}
+
+var testCodecs = codec.NewRegistry(codec.Json, testInstruction{}, testDone{}, servermsg.Cancel{})
+
+func (t *testSessionHandler) MarshalMessage(protocol string, m services.Message) ([]byte, int, error) {
+	return testCodecs.Marshal(protocol, m)
+}
+
+func (t *testSessionHandler) UnarshalMessage(protocol string, b []byte) (services.Message, error) {
+	return testCodecs.Unmarshal(protocol, b)
+}
+
+func TestSession(t *testing.T) {
+
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(&testSessionHandler{testSocketHandler{lane: config.Jsgo}}))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/_jsgo/?session"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.Close()
+	}()
+
+	for _, id := range []string{"a", "b"} {
+		command := `{"Type": "testInstruction", "ID": "` + id + `", "Message": {"Path": "p"}}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type envelope struct {
+		Type string
+		ID   string
+	}
+	var received []envelope
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for len(received) == 0 || received[len(received)-1] != (envelope{"Finished", "b"}) {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v after %v", err, received)
+		}
+		var e envelope
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "Queueing" || e.Type == "Trace" {
+			continue
+		}
+		received = append(received, e)
+	}
+
+	// The commands run one at a time, in order.
+	expected := []envelope{{"Session", ""}, {"testDone", "a"}, {"Finished", "a"}, {"testDone", "b"}, {"Finished", "b"}}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, received)
+		}
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	handler := &testBlockingHandler{testSessionHandler{testSocketHandler{lane: config.Jsgo}}, make(chan error, 10)}
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(handler))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/_jsgo/?session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.Close()
+	}()
+
+	// The Cancel follows more messages for the running command than the session buffers, and must
+	// still cancel it.
+	command := `{"Type": "testInstruction", "ID": "a", "Message": {"Path": "p"}}`
+	for i := 0; i < config.SessionQueueSize*2; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"Type": "Cancel", "ID": "a"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for {
+		var e struct{ Type, ID string }
+		if err := conn.ReadJSON(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "Cancelled" && e.ID == "a" {
+			break
+		}
+	}
+}
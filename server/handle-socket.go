@@ -4,13 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"reflect"
 	"runtime/debug"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/clientip"
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/jsgo/server/jobs"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/metrics"
+	"github.com/dave/jsgo/server/scheduler"
 	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/jsgo/server/trace"
+	"github.com/dave/jsgo/server/uploads"
 	"github.com/dave/services"
 	"github.com/dave/services/tracker"
 	"github.com/gorilla/websocket"
@@ -18,12 +31,15 @@ import (
 
 type SocketHandlerInterface interface {
 	Handle(ctx context.Context, req *http.Request, send func(message services.Message), receive chan services.Message, tj *tracker.Job) error
+	Lane() string
 	RequestTimeout() time.Duration
 	WebsocketPingPeriod() time.Duration
 	WebsocketTimeout() time.Duration
 	WebsocketPongTimeout() time.Duration
-	MarshalMessage(services.Message) (payload []byte, messageType int, err error)
-	UnarshalMessage([]byte) (services.Message, error)
+	Protocols() []string                   // Websocket subprotocols (see the codec package), with the default first
+	VersionNotSupported() services.Message // Sent to clients that request no supported subprotocol
+	MarshalMessage(protocol string, m services.Message) (payload []byte, messageType int, err error)
+	UnarshalMessage(protocol string, b []byte) (services.Message, error)
 	StoreError(ctx context.Context, err error, req *http.Request)
 }
 
@@ -31,36 +47,71 @@ func (h *Handler) SocketHandler(s SocketHandlerInterface) func(w http.ResponseWr
 
 	return func(w http.ResponseWriter, req *http.Request) {
 
+		if !h.Origins.Allowed(s.Lane(), req) {
+			// Don't store an error - a forged origin is the attack we're defending against, so storing these
+			// would allow the database to be flooded.
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
 		h.Waitgroup.Add(1)
 		defer func() {
 			h.Waitgroup.Done()
 		}()
 
-		tj := tracker.Default.Start()
+		// The connection context is cancelled when the client disconnects. The job context (below) is
+		// independent of the connection so the job can continue if the client reconnects.
+		connCtx, connCancel := context.WithCancel(req.Context())
 		defer func() {
-			tj.End()
-		}()
-
-		ctx, cancel := context.WithTimeout(req.Context(), s.RequestTimeout())
-		defer func() {
-			cancel()
+			connCancel()
 		}()
 
+		upgrader := websocket.Upgrader{
+			// Origins are checked above, using the policy for the route.
+			CheckOrigin:  func(r *http.Request) bool { return true },
+			Subprotocols: s.Protocols(),
+		}
 		conn, err := upgrader.Upgrade(w, req, nil)
 		if err != nil {
-			h.storeError(ctx, fmt.Errorf("upgrading request to websocket: %v", err), req)
+			h.storeError(connCtx, fmt.Errorf("upgrading request to websocket: %v", err), req)
 			return
 		}
 
+		// The codec is chosen by the client with the websocket subprotocol. Clients that don't request
+		// one get the default for the handler.
+		protocol := conn.Subprotocol()
+		if protocol == "" && len(websocket.Subprotocols(req)) > 0 {
+			// The client only speaks codecs or versions the server doesn't support, so it's told with
+			// the default codec and should be upgraded.
+			if b, messageType, err := s.MarshalMessage("", s.VersionNotSupported()); err == nil {
+				conn.SetWriteDeadline(time.Now().Add(s.WebsocketTimeout()))
+				conn.WriteMessage(messageType, b)
+			}
+			conn.Close()
+			return
+		}
+
+		metrics.ConnectionsTotal.WithLabelValues(s.Lane()).Inc()
+		metrics.Connections.WithLabelValues(s.Lane()).Inc()
+		defer func() {
+			metrics.Connections.WithLabelValues(s.Lane()).Dec()
+		}()
+
 		var sendWg sync.WaitGroup
 		sendCh := make(chan services.Message, 256)
-		receive := make(chan services.Message, 256)
 		var finished bool
 
+		// Clients that don't request a version of the messages with the subprotocol are only sent the
+		// messages they already know. Some fail on an unknown message, e.g. the play client.
+		versioned := codec.Version(protocol) != ""
+
 		send := func(message services.Message) {
 			if finished {
 				return // prevent more messages from being sent after we want to finish
 			}
+			if !versioned && unversioned(message) {
+				return
+			}
 			sendWg.Add(1)
 			sendCh <- message
 		}
@@ -72,20 +123,12 @@ func (h *Handler) SocketHandler(s SocketHandlerInterface) func(w http.ResponseWr
 			conn.Close()    // finally close the websocket
 		}()
 
-		// Recover from any panic and log the error.
-		defer func() {
-			if r := recover(); r != nil {
-				s.StoreError(ctx, fmt.Errorf("panic recovered: %s\n%s", r, string(debug.Stack())), req)
-				send(servermsg.Error{Message: fmt.Sprintf("panic recovered: %s\n%s", r, string(debug.Stack()))})
-			}
-		}()
-
 		// Set up a ticker to ping the client regularly
 		go func() {
 			ticker := time.NewTicker(s.WebsocketPingPeriod())
 			defer func() {
 				ticker.Stop()
-				cancel()
+				connCancel()
 			}()
 			for {
 				select {
@@ -97,7 +140,7 @@ func (h *Handler) SocketHandler(s SocketHandlerInterface) func(w http.ResponseWr
 					}
 					func() {
 						defer sendWg.Done()
-						b, messageType, err := s.MarshalMessage(message)
+						b, messageType, err := s.MarshalMessage(protocol, message)
 						if err != nil {
 							return
 						}
@@ -111,91 +154,354 @@ func (h *Handler) SocketHandler(s SocketHandlerInterface) func(w http.ResponseWr
 			}
 		}()
 
+		if _, ok := req.URL.Query()["session"]; ok {
+			// The connection stays open for any number of commands (see servermsg.Session).
+			receive := make(chan services.Message, config.SessionQueueSize)
+			go func() {
+				defer func() {
+					connCancel()
+				}()
+				h.read(connCtx, req, s, conn, protocol, receive)
+			}()
+			h.session(connCtx, req, s, send, receive)
+			return
+		}
+
+		var job *jobs.Job
+		var unsubscribe func()
+
+		if id := req.URL.Query().Get("job"); id != "" {
+			// The client is reconnecting to an existing job.
+			var ok bool
+			job, ok = h.Jobs.Get(id)
+			if !ok {
+				send(servermsg.Error{Message: fmt.Sprintf("job %s not found", id), Code: servermsg.CodeJobNotFound})
+				return
+			}
+			from, _ := strconv.Atoi(req.URL.Query().Get("from"))
+			job.Tracker.Log("resumed")
+			unsubscribe = job.Subscribe(send, from)
+		} else {
+			tj := tracker.Default.Start()
+			ctx, cancel := context.WithTimeout(context.Background(), s.RequestTimeout())
+
+			job, err = h.Jobs.Start(tj, cancel)
+			if err != nil {
+				s.StoreError(ctx, err, req)
+				send(servermsg.NewError(err))
+				cancel()
+				tj.End()
+				return
+			}
+			unsubscribe = job.Subscribe(send, 0)
+			ctx = logger.NewContext(ctx, h.Log.With("job", job.ID))
+
+			// The job ID is always the first message (for clients that request a version of the messages).
+			job.Send(servermsg.Job{ID: job.ID})
+
+			// The job runs independently of the connection, so it can continue if the client disconnects
+			// and reconnects.
+			h.Waitgroup.Add(1)
+			go func() {
+				defer func() {
+					job.Finish()
+					cancel()
+					tj.End()
+					h.Waitgroup.Done()
+				}()
+				h.run(ctx, cancel, req, s, job.Send, job.Receive, tj)
+			}()
+		}
+
+		// Detach from the job before the connection is closed.
+		defer func() {
+			unsubscribe()
+		}()
+
 		// React to pongs from the client
 		go func() {
 			defer func() {
-				cancel()
+				unsubscribe()
+				connCancel()
 			}()
-			conn.SetReadDeadline(time.Now().Add(s.WebsocketPongTimeout()))
-			conn.SetPongHandler(func(string) error {
-				conn.SetReadDeadline(time.Now().Add(s.WebsocketPongTimeout()))
-				return nil
-			})
-			for {
-				messageType, messageBytes, err := conn.ReadMessage()
-				if err != nil {
-					if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-						// Don't bother storing an error if the client disconnects gracefully
-						break
-					}
-					if err, ok := err.(*net.OpError); ok && err.Err.Error() == "use of closed network connection" {
-						// Don't bother storing an error if the client disconnects gracefully
-						break
-					}
-					h.storeError(ctx, err, req)
-					break
-				}
-				if messageType == websocket.CloseMessage {
-					break
-				}
-				message, err := s.UnarshalMessage(messageBytes)
-				if err != nil {
-					h.storeError(ctx, err, req)
-					break
+			h.read(connCtx, req, s, conn, protocol, job.Receive)
+		}()
+
+		// Keep the connection open until the job finishes. If the client disconnects first, the job
+		// continues in the background until it finishes or config.JobReconnectTimeout expires.
+		select {
+		case <-job.Done():
+		case <-connCtx.Done():
+		}
+	}
+}
+
+// unversioned returns true for messages added since the clients that don't request a version of the
+// messages (see codec.Version).
+func unversioned(message services.Message) bool {
+	_, message = codec.Split(message)
+	switch message.(type) {
+	case servermsg.Job, servermsg.Trace:
+		return true
+	}
+	return false
+}
+
+// run requests a slot in the queue, waits for it to become available and then runs the handler. This
+// is the admission path shared by the websocket and HTTP API endpoints.
+func (h *Handler) run(ctx context.Context, cancel context.CancelFunc, req *http.Request, s SocketHandlerInterface, send func(services.Message), receive chan services.Message, tj *tracker.Job) {
+
+	// Start the trace of the job, and send the ID to the client.
+	ctx, span := trace.Start(ctx, s.Lane())
+	defer span.Finish()
+	send(servermsg.Trace{ID: span.TraceID})
+
+	// Log entries for the job include the route, client and trace ID.
+	log := logger.FromContext(ctx, h.Log).With("route", s.Lane(), "ip", clientip.Get(req), "trace", span.TraceID)
+	ctx = logger.NewContext(ctx, log)
+	started := time.Now()
+	log.Debug("job started")
+
+	// Record the queue wait, the phase durations and the errors sent.
+	mj := metrics.NewJob(s.Lane())
+	defer mj.End()
+	send = mj.Send(send)
+
+	// Recover from any panic and log the error.
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic recovered: %s\n%s", r, string(debug.Stack()))
+			s.StoreError(ctx, err, req)
+			send(servermsg.Error{Message: err.Error(), Code: servermsg.CodePanic})
+		}
+	}()
+
+	// React to the server shutdown signal. The goroutine gets its own copy of ctx, because ctx is
+	// replaced below when the path is added to the logger.
+	go func(ctx context.Context) {
+		select {
+		case <-h.shutdown:
+			s.StoreError(ctx, errors.New("server shut down"), req)
+			send(servermsg.Error{Message: "server shut down", Code: servermsg.CodeShutdown, Retryable: true})
+			cancel()
+		case <-ctx.Done():
+		}
+	}(ctx)
+
+	// Apply the per-client rate limit before doing any work.
+	if ok, retry := h.SocketLimits.AllowClient(s.Lane(), clientip.Get(req)); !ok {
+		tj.Log("rate limited")
+		send(rateLimited(retry))
+		return
+	}
+
+	// Wait for the instruction from the client, so the rate limit for the requested package path can be
+	// applied before joining the queue.
+	var instruction services.Message
+	select {
+	case instruction = <-receive:
+		// continue
+	case <-time.After(config.WebsocketInstructionTimeout):
+		tj.Log("timeout")
+		err := errors.New("timed out waiting for instruction from client")
+		s.StoreError(ctx, err, req)
+		send(servermsg.Error{Message: err.Error(), Code: servermsg.CodeTimeout})
+		return
+	case <-ctx.Done():
+		return
+	}
+	if _, ok := instruction.(servermsg.Cancel); ok {
+		tj.Log("cancelled")
+		send(servermsg.Cancelled{})
+		return
+	}
+	span.Attributes["path"] = messagePath(instruction)
+	log = log.With("path", messagePath(instruction))
+	ctx = logger.NewContext(ctx, log)
+	if ok, retry := h.SocketLimits.AllowPath(s.Lane(), clientip.Get(req), messagePath(instruction)); !ok {
+		tj.Log("rate limited")
+		send(rateLimited(retry))
+		return
+	}
+
+	// The handler reads the instruction from the channel, so we pass it on followed by any subsequent
+	// messages from the client. A Cancel message cancels the job instead.
+	var cancelled int32
+	instructions := make(chan services.Message, cap(receive)+1)
+	instructions <- instruction
+	go func(ctx context.Context) {
+		for {
+			select {
+			case message := <-receive:
+				if _, ok := message.(servermsg.Cancel); ok {
+					atomic.StoreInt32(&cancelled, 1)
+					cancel()
+					continue
 				}
 				select {
-				case receive <- message:
+				case instructions <- message:
 				default:
 				}
-			}
-		}()
-
-		// React to the server shutdown signal
-		go func() {
-			select {
-			case <-h.shutdown:
-				s.StoreError(ctx, errors.New("server shut down"), req)
-				send(servermsg.Error{Message: "server shut down"})
-				cancel()
 			case <-ctx.Done():
+				return
 			}
-		}()
+		}
+	}(ctx)
 
-		// Request a slot in the queue...
-		start, end, err := h.Queue.Slot(func(position int) {
-			tj.Queue(position)
-			send(servermsg.Queueing{Position: position})
-		})
-		if err != nil {
-			s.StoreError(ctx, err, req)
-			send(servermsg.Error{Message: err.Error()})
-			return
+	// Request a slot in the queue...
+	start, end, err := h.Queue.Slot(ctx, s.Lane(), clientip.Get(req), func(position int, wait time.Duration) {
+		tj.Queue(position)
+		send(servermsg.Queueing{Lane: s.Lane(), Position: position, Wait: int(wait.Seconds())})
+	})
+	if err == scheduler.Draining {
+		tj.Log("draining")
+		log.Info("job rejected", "reason", "draining")
+		send(draining())
+		return
+	}
+	if err != nil {
+		s.StoreError(ctx, err, req)
+		e := servermsg.NewError(err)
+		if scheduler.IsFlood(err) {
+			e.Code, e.Retryable = servermsg.CodeQueueFull, true
 		}
+		send(e)
+		return
+	}
 
-		// Signal to the queue that processing has finished.
-		defer func() {
-			close(end)
-		}()
+	// Signal to the queue that processing has finished.
+	defer func() {
+		close(end)
+	}()
 
-		// Wait for the slot to become available.
-		select {
-		case <-start:
-			// continue
-		case <-ctx.Done():
-			return
+	// Wait for the slot to become available.
+	_, queueSpan := trace.Start(ctx, "queue")
+	queued := time.Now()
+	metrics.QueueDepth.WithLabelValues(s.Lane()).Inc()
+	dequeued := func() {
+		metrics.QueueDepth.WithLabelValues(s.Lane()).Dec()
+		queueSpan.Finish()
+	}
+	select {
+	case <-start:
+		dequeued()
+		metrics.QueueWait.WithLabelValues(s.Lane()).Observe(time.Since(queued).Seconds())
+		log.Debug("job dequeued", "wait", time.Since(queued))
+	case <-h.Queue.Draining():
+		dequeued()
+		tj.Log("draining")
+		log.Info("job rejected", "reason", "draining")
+		send(draining())
+		return
+	case <-ctx.Done():
+		dequeued()
+		if atomic.LoadInt32(&cancelled) == 1 {
+			tj.Log("cancelled")
+			log.Info("job cancelled", "phase", "queue", "duration", time.Since(started))
+			send(servermsg.Cancelled{})
 		}
+		return
+	}
 
-		tj.QueueDone()
+	tj.QueueDone()
 
-		// Send a message to the client that queue step has finished.
-		send(servermsg.Queueing{Done: true})
+	// Send a message to the client that queue step has finished.
+	send(servermsg.Queueing{Lane: s.Lane(), Done: true})
 
-		if err := s.Handle(ctx, req, send, receive, tj); err != nil {
-			s.StoreError(ctx, err, req)
-			send(servermsg.Error{Message: err.Error()})
+	// Files overwritten by the job are recorded so they can be rolled back if the job is cancelled.
+	jobCtx, journal := uploads.NewContext(ctx)
+
+	if err := s.Handle(jobCtx, req, send, instructions, tj); err != nil {
+		if atomic.LoadInt32(&cancelled) == 1 {
+			tj.Log("cancelled")
+			cleanCtx, cleanCancel := context.WithTimeout(context.Background(), config.StoreTimeout)
+			defer cleanCancel()
+			journal.Clean(cleanCtx)
+			log.Info("job cancelled", "duration", time.Since(started))
+			send(servermsg.Cancelled{})
 			return
 		}
-
+		span.Fail(err)
+		s.StoreError(ctx, err, req)
+		send(servermsg.NewError(err))
 		return
 	}
+
+	log.Info("job finished", "duration", time.Since(started))
+}
+
+// read reads messages from the client until the connection is closed, and sends them to receive. Pongs
+// extend the read deadline.
+func (h *Handler) read(ctx context.Context, req *http.Request, s SocketHandlerInterface, conn *websocket.Conn, protocol string, receive chan services.Message) {
+	conn.SetReadDeadline(time.Now().Add(s.WebsocketPongTimeout()))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(s.WebsocketPongTimeout()))
+		return nil
+	})
+	for {
+		messageType, messageBytes, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				// Don't bother storing an error if the client disconnects gracefully
+				break
+			}
+			if err, ok := err.(*net.OpError); ok && err.Err.Error() == "use of closed network connection" {
+				// Don't bother storing an error if the client disconnects gracefully
+				break
+			}
+			h.storeError(ctx, err, req)
+			break
+		}
+		if messageType == websocket.CloseMessage {
+			break
+		}
+		message, err := s.UnarshalMessage(protocol, messageBytes)
+		if err != nil {
+			h.storeError(ctx, err, req)
+			break
+		}
+		if _, inner := codec.Split(message); inner == (servermsg.Cancel{}) {
+			// A Cancel is never dropped, so wait until the job or session reads it.
+			select {
+			case receive <- message:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case receive <- message:
+		default:
+		}
+	}
+}
+
+// messagePath returns the package path requested by an instruction from the client, or an empty string
+// if it doesn't have a Path field.
+func messagePath(message services.Message) string {
+	v := reflect.ValueOf(message)
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName("Path")
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
+
+// rateLimited returns the error sent to the client when a request is rejected by the rate limiter.
+func rateLimited(retry time.Duration) servermsg.Error {
+	seconds := int(math.Ceil(retry.Seconds()))
+	return servermsg.Error{
+		Message:    fmt.Sprintf("Sorry, too many requests - try again in %d seconds.", seconds),
+		Code:       servermsg.CodeRateLimited,
+		Retryable:  true,
+		RetryAfter: seconds,
+	}
+}
+
+// draining is sent to clients that haven't started when the server starts draining (see Handler.Drain).
+func draining() servermsg.Error {
+	return servermsg.Error{Message: scheduler.Draining.Error(), Code: servermsg.CodeDraining, Retryable: true}
 }
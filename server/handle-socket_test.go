@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+	"github.com/gorilla/websocket"
+)
+
+// testBlockingHandler runs until the job is cancelled.
+type testBlockingHandler struct {
+	testSessionHandler
+	stored chan error
+}
+
+func (t *testBlockingHandler) Handle(ctx context.Context, req *http.Request, send func(message services.Message), receive chan services.Message, tj *tracker.Job) error {
+	<-receive
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (t *testBlockingHandler) StoreError(ctx context.Context, err error, req *http.Request) {
+	t.stored <- err
+}
+
+func TestCancel(t *testing.T) {
+
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	handler := &testBlockingHandler{testSessionHandler{testSocketHandler{lane: config.Jsgo}}, make(chan error, 10)}
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(handler))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/_jsgo/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, message := range []string{
+		`{"Type": "testInstruction", "Message": {"Path": "p"}}`,
+		`{"Type": "Cancel"}`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e struct{ Type string }
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "Error" {
+			t.Fatalf("unexpected error: %s", b)
+		}
+		if e.Type == "Cancelled" {
+			break
+		}
+	}
+
+	select {
+	case err := <-handler.stored:
+		t.Fatalf("unexpected stored error: %v", err)
+	default:
+	}
+}
+
+func TestDrain(t *testing.T) {
+
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	handler := &testBlockingHandler{testSessionHandler{testSocketHandler{lane: config.Jsgo}}, make(chan error, 10)}
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(handler))
+	h.mux.HandleFunc("/_ah/health", h.HealthCheckHandler)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// read returns the type of the next message that isn't Queueing or Job.
+	read := func(conn *websocket.Conn) string {
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e struct {
+				Type    string
+				Message struct{ Code string }
+			}
+			if err := json.Unmarshal(b, &e); err != nil {
+				t.Fatal(err)
+			}
+			switch e.Type {
+			case "Queueing", "Job", "Trace":
+				continue
+			case "Error":
+				return e.Message.Code
+			}
+			return e.Type
+		}
+	}
+
+	// The test handler has one worker, so the first job runs and the second waits in the queue.
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/_jsgo/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"Type": "testInstruction", "Message": {"Path": "p"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	h.Drain()
+
+	if code := read(conns[1]); code != "draining" {
+		t.Fatalf("expected draining, got %s", code)
+	}
+
+	resp, err := http.Get(s.URL + "/_ah/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected health check to fail, got %d", resp.StatusCode)
+	}
+
+	// The running job isn't affected by the drain.
+	if err := conns[0].WriteMessage(websocket.TextMessage, []byte(`{"Type": "Cancel"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if typ := read(conns[0]); typ != "Cancelled" {
+		t.Fatalf("expected Cancelled, got %s", typ)
+	}
+}
+
+func TestVersionNotSupported(t *testing.T) {
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(&testSocketHandler{lane: config.Jsgo}))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"json.v2"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/_jsgo/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	var e servermsg.Error
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != servermsg.CodeVersionNotSupported {
+		t.Fatalf("expected %s, got %#v", servermsg.CodeVersionNotSupported, e)
+	}
+}
+
+func TestVersionedMessages(t *testing.T) {
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(&testSessionHandler{testSocketHandler{lane: config.Jsgo}}))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// Clients that don't request a version of the messages aren't sent the messages added since.
+	for _, protocol := range []string{"", "json.v1"} {
+		dialer := websocket.Dialer{}
+		if protocol != "" {
+			dialer.Subprotocols = []string{protocol}
+		}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/_jsgo/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"Type": "testInstruction", "Message": {"Path": "p"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		types := map[string]bool{}
+		for !types["testDone"] {
+			var e struct{ Type string }
+			if err := conn.ReadJSON(&e); err != nil {
+				t.Fatal(err)
+			}
+			types[e.Type] = true
+		}
+		if types["Job"] != (protocol != "") || types["Trace"] != (protocol != "") {
+			t.Errorf("%q: unexpected messages %v", protocol, types)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+)
+
+func New() *Registry {
+	return &Registry{
+		jobs: map[string]*Job{},
+	}
+}
+
+// Registry keeps track of running jobs (and recently finished jobs) so a client can reconnect to a job
+// after the websocket that started it has dropped.
+type Registry struct {
+	sync.Mutex
+	jobs map[string]*Job
+}
+
+// Job is a single compile / deploy job. All messages sent to the client are kept in a bounded log so
+// they can be replayed to a reconnecting client.
+type Job struct {
+	ID      string
+	Tracker *tracker.Job
+
+	// Receive is the channel of messages from the client. Messages from any connection attached to the
+	// job are forwarded here.
+	Receive chan services.Message
+
+	sync.Mutex
+	registry    *Registry
+	cancel      context.CancelFunc
+	log         []services.Message
+	dropped     int // number of messages that have been dropped from the start of the log
+	subscribers map[*subscriber]bool
+	timer       *time.Timer
+	finished    bool
+	done        chan struct{}
+}
+
+// subscriber is a client attached to a job. Messages are sent to it outside the job lock, so a slow
+// client doesn't hold up the job. The subscriber lock keeps its messages in order.
+type subscriber struct {
+	sync.Mutex
+	send    func(services.Message)
+	next    int  // index in the log (including dropped messages) of the next message to send
+	removed bool // set by unsubscribe, after which nothing more is sent
+}
+
+// deliver sends the messages in the log the subscriber hasn't been sent yet.
+func (s *subscriber) deliver(j *Job) {
+	s.Lock()
+	defer s.Unlock()
+	if s.removed {
+		return
+	}
+	j.Lock()
+	start := s.next - j.dropped
+	if start < 0 {
+		start = 0
+	}
+	messages := append([]services.Message(nil), j.log[start:]...)
+	s.next = j.dropped + len(j.log)
+	j.Unlock()
+	for _, message := range messages {
+		s.send(message)
+	}
+}
+
+// Start registers a new job. The cancel function is called if the client disconnects and doesn't
+// reconnect within config.JobReconnectTimeout.
+func (r *Registry) Start(tj *tracker.Job, cancel context.CancelFunc) (*Job, error) {
+	id, err := newId()
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{
+		ID:          id,
+		Tracker:     tj,
+		Receive:     make(chan services.Message, 256),
+		registry:    r,
+		cancel:      cancel,
+		subscribers: map[*subscriber]bool{},
+		done:        make(chan struct{}),
+	}
+	tj.Log(fmt.Sprintf("job %s", id))
+	r.Lock()
+	defer r.Unlock()
+	r.jobs[id] = j
+	return j, nil
+}
+
+// Get returns the job with the specified ID.
+func (r *Registry) Get(id string) (*Job, bool) {
+	r.Lock()
+	defer r.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+// Send adds a message to the log and sends it to all attached clients.
+func (j *Job) Send(message services.Message) {
+	j.Lock()
+	if j.finished {
+		j.Unlock()
+		return
+	}
+	j.log = append(j.log, message)
+	if len(j.log) > config.JobLogSize {
+		j.log = j.log[1:]
+		j.dropped++
+	}
+	subscribers := make([]*subscriber, 0, len(j.subscribers))
+	for s := range j.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	j.Unlock()
+
+	for _, s := range subscribers {
+		s.deliver(j)
+	}
+}
+
+// Subscribe attaches a client to the job. Messages in the log are replayed, starting at from (the
+// number of messages the client has already received), and all subsequent messages are sent until
+// unsubscribe is called.
+func (j *Job) Subscribe(send func(services.Message), from int) (unsubscribe func()) {
+	j.Lock()
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	if total := j.dropped + len(j.log); from > total {
+		from = total
+	}
+	s := &subscriber{send: send, next: from}
+	j.subscribers[s] = true
+	j.Unlock()
+
+	s.deliver(j)
+
+	return func() {
+		s.Lock()
+		s.removed = true
+		s.Unlock()
+
+		j.Lock()
+		defer j.Unlock()
+		delete(j.subscribers, s)
+		if len(j.subscribers) == 0 && !j.finished && j.timer == nil {
+			// Nobody is listening... give the client a chance to reconnect before cancelling.
+			j.timer = time.AfterFunc(config.JobReconnectTimeout, j.cancel)
+		}
+	}
+}
+
+// Finish marks the job as finished. The job will be available for replay for config.JobRetention
+// before it is removed from the registry.
+func (j *Job) Finish() {
+	j.Lock()
+	defer j.Unlock()
+	if j.finished {
+		return
+	}
+	j.finished = true
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	close(j.done)
+	time.AfterFunc(config.JobRetention, func() {
+		j.registry.Lock()
+		defer j.registry.Unlock()
+		delete(j.registry.jobs, j.ID)
+	})
+}
+
+// Done is closed when the job finishes.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func newId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+)
+
+type message int
+
+// recorder records the messages sent to a subscriber.
+type recorder struct {
+	sync.Mutex
+	messages []services.Message
+}
+
+func (r *recorder) send(m services.Message) {
+	r.Lock()
+	defer r.Unlock()
+	r.messages = append(r.messages, m)
+}
+
+func (r *recorder) get() []services.Message {
+	r.Lock()
+	defer r.Unlock()
+	return append([]services.Message(nil), r.messages...)
+}
+
+func TestReplay(t *testing.T) {
+	j, err := New().Start(tracker.Default.Start(), func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < config.JobLogSize+10; i++ {
+		j.Send(message(i))
+	}
+
+	// A client reconnecting after receiving 20 messages gets the rest of the log.
+	r := &recorder{}
+	unsubscribe := j.Subscribe(r.send, 20)
+	j.Send(message(config.JobLogSize + 10))
+	if m := r.get(); len(m) != config.JobLogSize-9 || m[0] != message(20) || m[len(m)-1] != message(config.JobLogSize+10) {
+		t.Fatalf("unexpected replay of %d messages from %v", len(m), m[0])
+	}
+
+	// Messages that have been dropped from the log can't be replayed.
+	dropped := &recorder{}
+	j.Subscribe(dropped.send, 0)
+	if m := dropped.get(); len(m) != config.JobLogSize || m[0] != message(11) {
+		t.Fatalf("unexpected replay of %d messages from %v", len(m), m[0])
+	}
+
+	// Nothing is sent after unsubscribe or after the job finishes.
+	unsubscribe()
+	j.Send(message(-1))
+	if m := r.get(); m[len(m)-1] == message(-1) {
+		t.Fatal("message sent after unsubscribe")
+	}
+	j.Finish()
+	j.Send(message(-2))
+	if m := dropped.get(); m[len(m)-1] == message(-2) {
+		t.Fatal("message sent after finish")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	defer func(d time.Duration) { config.JobReconnectTimeout = d }(config.JobReconnectTimeout)
+	config.JobReconnectTimeout = time.Millisecond * 50
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j, err := New().Start(tracker.Default.Start(), cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that reconnects in time keeps the job running.
+	unsubscribe := j.Subscribe(func(services.Message) {}, 0)
+	unsubscribe()
+	unsubscribe = j.Subscribe(func(services.Message) {}, 0)
+	time.Sleep(config.JobReconnectTimeout * 2)
+	if ctx.Err() != nil {
+		t.Fatal("job cancelled while a client was attached")
+	}
+
+	// The job is cancelled if nobody reconnects.
+	unsubscribe()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("job not cancelled")
+	}
+}
@@ -0,0 +1,123 @@
+package jsgo
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dave/jsgo/assets/std"
+	"github.com/dave/jsgo/config"
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// cacheKey resolves the current commit of every repo in the dependency tree of path and hashes them
+// together with the build options. The repos in the tree are taken from the git hints saved by the
+// previous compile. If the tree or any commit has changed since the last compile, the key will be
+// different. An empty key means there's not enough information to use the cache.
+func (h *Handler) cacheKey(ctx context.Context, path string, tags []string, minify map[bool]bool) (string, error) {
+	urls, err := h.Cache.ResolveHints(ctx, []string{path})
+	if err != nil {
+		return "", err
+	}
+	if len(urls) == 0 {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.HttpTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var outer error
+	commits := map[string]string{}
+	for _, url := range urls {
+		url := url
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			commit, err := resolveHead(ctx, url)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				outer = err
+				return
+			}
+			commits[url] = commit
+		}()
+	}
+	wg.Wait()
+	if outer != nil {
+		return "", outer
+	}
+
+	sort.Strings(urls)
+	tags = append([]string(nil), tags...)
+	sort.Strings(tags)
+
+	sha := sha1.New()
+	for _, url := range urls {
+		fmt.Fprintf(sha, "repo %s %s\n", url, commits[url])
+	}
+	fmt.Fprintf(sha, "tags %q\n", tags)
+	fmt.Fprintf(sha, "minify %t %t\n", minify[true], minify[false])
+	// The prelude hashes change when the compiler or standard library archives are updated.
+	fmt.Fprintf(sha, "prelude %s %s\n", std.Prelude[true], std.Prelude[false])
+	return fmt.Sprintf("%x", sha.Sum(nil)), nil
+}
+
+// resolveHead finds the commit at HEAD of the remote repo, without fetching it (like git ls-remote).
+func resolveHead(ctx context.Context, url string) (string, error) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	type result struct {
+		refs []*plumbing.Reference
+		err  error
+	}
+	c := make(chan result, 1)
+	go func() {
+		refs, err := remote.List(&git.ListOptions{})
+		c <- result{refs, err}
+	}()
+
+	var refs []*plumbing.Reference
+	select {
+	case r := <-c:
+		if r.err != nil {
+			return "", r.err
+		}
+		refs = r.refs
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	// Find the HEAD reference. If we can't find it, return an error.
+	rs := memory.ReferenceStorage{}
+	for _, ref := range refs {
+		rs[ref.Name()] = ref
+	}
+	head, err := storer.ResolveReference(rs, plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+	if head == nil {
+		return "", errors.New("HEAD not found")
+	}
+	return head.Hash().String(), nil
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/dave/jsgo/assets"
 	"github.com/dave/jsgo/assets/std"
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/clientip"
 	"github.com/dave/jsgo/server/jsgo/messages"
+	"github.com/dave/jsgo/server/metrics"
+	"github.com/dave/jsgo/server/modules"
+	"github.com/dave/jsgo/server/pin"
 	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/jsgo/server/store"
+	"github.com/dave/jsgo/server/trace"
 	"github.com/dave/services"
 	"github.com/dave/services/deployer"
+	"github.com/dave/services/getter/cache"
 	"github.com/dave/services/getter/get"
 	"github.com/dave/services/getter/gettermsg"
 	"github.com/dave/services/session"
@@ -22,71 +29,235 @@ import (
 
 func (h *Handler) Compile(ctx context.Context, info messages.Compile, req *http.Request, send func(services.Message), receive chan services.Message) error {
 
+	// Compiles with non-default options are stored separately, and don't replace the index page at the
+	// package path.
+	name := store.PackageName(info.Path, info.Version, info.Tags, info.MinifyOnly)
+
+	s := session.New(info.Tags, assets.Assets, assets.Archives, h.Fileserver, config.ValidExtensions)
+
+	if err := h.compile(ctx, info, name, s, req, send); err != nil {
+		if ctx.Err() != context.Canceled {
+			h.storeFailure(s, name, info, err, req)
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) compile(ctx context.Context, info messages.Compile, name string, s *session.Session, req *http.Request, send func(services.Message)) error {
+
 	path := info.Path
+	version := info.Version
+
+	// Compile both the minified and non-minified versions, unless MinifyOnly is set.
+	minify := map[bool]bool{true: true, false: !info.MinifyOnly}
 
-	s := session.New(nil, assets.Assets, assets.Archives, h.Fileserver, config.ValidExtensions)
+	index := deployer.PathIndex
+	if name != path {
+		index = deployer.HashIndex
+	}
+
+	// If nothing in the dependency tree has changed since the last compile, we can return the previous
+	// result. Errors here aren't fatal - we just compile as normal. The key is computed from the commits
+	// at HEAD, so it's no use for compiles pinned to a version.
+	var key string
+	if !config.LOCAL && version == "" {
+		var err error
+		if key, err = h.cacheKey(ctx, path, info.Tags, minify); err != nil {
+			key = ""
+		}
+		if key != "" {
+			found, data, err := store.Package(ctx, h.Database, name)
+			if err == nil && found && data.Success && data.Key == key {
+				send(messages.Cached{Time: data.Time})
+				complete := messages.Complete{
+					Path:    path,
+					Short:   strings.TrimPrefix(path, "github.com/"),
+					HashMin: data.Min.Main,
+					HashMax: data.Max.Main,
+					Modules: modulesMap(data.Modules),
+					Version: data.Version,
+					Commit:  data.Commit,
+				}
+				if index == deployer.HashIndex {
+					complete.IndexMin = data.Min.Index
+					complete.IndexMax = data.Max.Index
+				}
+				send(complete)
+				return nil
+			}
+		}
+	}
 
 	// Send a message to the client that downloading step has started.
 	send(gettermsg.Downloading{Starting: true})
 
-	gitreq := h.Cache.NewRequest(true)
-	if err := gitreq.InitialiseFromHints(ctx, path); err != nil {
-		return err
+	// The dependencies of a pinned compile might not match the hints for the default branch, so we
+	// don't use or save them.
+	var p *pin.Pin
+	gitreq := h.Cache.NewRequest(version == "")
+	if version != "" {
+		ctx, p = pin.WithRef(ctx, path, version)
+	} else if err := trace.Run(ctx, "InitialiseFromHints", func(ctx context.Context) error {
+		return gitreq.InitialiseFromHints(ctx, path)
+	}); err != nil {
+		return servermsg.Failed(servermsg.PhaseDownload, path, err)
 	}
 
 	// set insecure = true in local mode or it will fail if git repo has git protocol
 	insecure := config.LOCAL
 
-	// Start the download process - just like the "go get" command.
-	if err := get.New(s, send, gitreq).Get(ctx, path, false, insecure, false); err != nil {
-		return err
+	versions, err := h.download(ctx, s, send, gitreq, path, insecure)
+	if err != nil {
+		return servermsg.Failed(servermsg.PhaseDownload, path, err)
 	}
 
 	if err := gitreq.Close(ctx); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, path, err)
+	}
+
+	var commit string
+	if p != nil {
+		if commit = p.Commit(); commit == "" {
+			return servermsg.Invalid(fmt.Errorf("can't build %s at %s - only packages hosted at their import path can be pinned to a version", path, version))
+		}
 	}
 
 	// Send a message to the client that downloading step has finished.
 	send(gettermsg.Downloading{Done: true})
 
 	// Start the compile process - this compiles to JS and sends the files to a GCS bucket.
-	output, err := deployer.New(s, send, std.Index, std.Prelude, config.DeployerConfig).Deploy(ctx, path, deployer.PathIndex, map[bool]bool{true: true, false: true})
-	if err != nil {
+	var output map[bool]*deployer.DeployOutput
+	err = trace.Run(ctx, "deploy", func(ctx context.Context) error {
+		send, end := trace.Builds(ctx, send)
+		defer end()
+		var err error
+		output, err = deployer.New(s, send, std.Index, std.Prelude, config.DeployerConfig).Deploy(ctx, path, index, minify)
 		return err
+	}, "path", path)
+	if err != nil {
+		return servermsg.Failed(servermsg.PhaseBuild, path, err)
+	}
+	for _, o := range output {
+		metrics.StdArchives(o.Packages)
+	}
+
+	if index == deployer.PathIndex {
+		if err := h.archiveIndex(ctx, send, path, output); err != nil {
+			return servermsg.Failed(servermsg.PhaseStore, path, err)
+		}
 	}
 
 	// Logs the success in the datastore
-	h.storeCompile(ctx, send, path, req, output)
+	data := store.CompileData{
+		Path:       path,
+		Version:    version,
+		Commit:     commit,
+		Modules:    modulesSlice(versions),
+		Key:        key,
+		Tags:       info.Tags,
+		MinifyOnly: info.MinifyOnly,
+		Min:        getCompileContents(output[true], true),
+		Max:        getCompileContents(output[false], false),
+	}
+	h.storeCompile(ctx, send, name, data, req)
 
 	// Send a message to the client that the process has successfully finished
-	send(messages.Complete{
+	complete := messages.Complete{
 		Path:    path,
 		Short:   strings.TrimPrefix(path, "github.com/"),
-		HashMin: fmt.Sprintf("%x", output[true].MainHash),
-		HashMax: fmt.Sprintf("%x", output[false].MainHash),
-	})
+		HashMin: data.Min.Main,
+		HashMax: data.Max.Main,
+		Modules: versions,
+		Version: version,
+		Commit:  commit,
+	}
+	if index == deployer.HashIndex {
+		complete.IndexMin = data.Min.Index
+		complete.IndexMax = data.Max.Index
+	}
+	send(complete)
 	return nil
 }
 
-func (h *Handler) storeCompile(ctx context.Context, send func(services.Message), path string, req *http.Request, output map[bool]*deployer.DeployOutput) {
-	data := store.CompileData{
-		Path:    path,
-		Time:    time.Now(),
-		Min:     getCompileContents(output[true], true),
-		Max:     getCompileContents(output[false], false),
-		Ip:      req.Header.Get("X-Forwarded-For"),
-		Success: true,
+// download gets the package and its dependencies, and returns the module versions used. The package
+// is downloaded first, so we can check for a go.mod file. Each repo downloaded gets a span.
+func (h *Handler) download(ctx context.Context, s *session.Session, send func(services.Message), gitreq *cache.Request, path string, insecure bool) (map[string]string, error) {
+	getSend, endGet := trace.Gets(ctx, send)
+	defer endGet()
+	g := get.New(s, getSend, gitreq)
+	if err := trace.Run(ctx, "get", func(ctx context.Context) error {
+		return g.Get(ctx, path, false, insecure, true)
+	}, "path", path); err != nil {
+		return nil, err
+	}
+
+	// If the package is in a module, place the required module versions in GOPATH so the getter doesn't
+	// download the default branch.
+	modDir, mod, sum, err := modules.Find(s.GoPath(), path)
+	if err != nil {
+		return nil, err
+	}
+	var versions map[string]string
+	if mod != nil {
+		if versions, err = modules.Resolve(ctx, s.GoPath(), send, modDir, mod, sum); err != nil {
+			return nil, err
+		}
+	}
+
+	// Download the dependencies - just like the "go get" command.
+	if err := trace.Run(ctx, "get", func(ctx context.Context) error {
+		return g.Get(ctx, path, false, insecure, false)
+	}, "path", path, "dependencies", "true"); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// modulesSlice converts the module versions of messages.Complete to store.CompileData.Modules, sorted
+// by path.
+func modulesSlice(versions map[string]string) []store.CompileModule {
+	var out []store.CompileModule
+	for path, version := range versions {
+		out = append(out, store.CompileModule{Path: path, Version: version})
 	}
-	if err := store.StoreCompile(ctx, h.Database, path, data); err != nil {
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// modulesMap converts store.CompileData.Modules back to the module versions of messages.Complete.
+func modulesMap(modules []store.CompileModule) map[string]string {
+	if len(modules) == 0 {
+		return nil
+	}
+	out := map[string]string{}
+	for _, m := range modules {
+		out[m.Path] = m.Version
+	}
+	return out
+}
+
+// storeCompile stores the compile data under the package name (see store.PackageName).
+func (h *Handler) storeCompile(ctx context.Context, send func(services.Message), name string, data store.CompileData, req *http.Request) {
+	data.Time = time.Now()
+	data.Ip = req.Header.Get("X-Forwarded-For")
+	data.Client = clientip.Get(req)
+	data.Success = true
+	if err := store.StoreCompile(ctx, h.Database, name, data); err != nil {
 		// don't save this one to the datastore because it's an error from the datastore.
-		send(servermsg.Error{Message: err.Error()})
+		send(servermsg.NewError(servermsg.Failed(servermsg.PhaseStore, name, err)))
 		return
 	}
 }
 
 func getCompileContents(c *deployer.DeployOutput, min bool) store.CompileContents {
 	val := store.CompileContents{}
+	if c == nil {
+		// This version wasn't built (see messages.Compile.MinifyOnly)
+		return val
+	}
 	val.Main = fmt.Sprintf("%x", c.MainHash)
+	val.Index = fmt.Sprintf("%x", c.IndexHash)
 	preludeHash := std.Prelude[min]
 	val.Packages = []store.CompilePackage{
 		{
@@ -0,0 +1,113 @@
+package jsgo
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+
+	"github.com/dave/jsgo/server/store"
+	"github.com/dustin/go-humanize"
+)
+
+// errorsPage shows the latest failed compile of a package (compile.jsgo.io/<path>?errors).
+// prefix is the path the page is mounted at (see server.Router).
+func errorsPage(ctx context.Context, w http.ResponseWriter, database store.Database, prefix, path, name string) {
+
+	found, data, err := store.Failure(ctx, database, name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	type line struct {
+		Number    int
+		Text      string
+		Highlight bool
+	}
+
+	type diagnostic struct {
+		store.CompileError
+		Lines []line
+	}
+
+	type vars struct {
+		Found       bool
+		Prefix      string
+		Path        string
+		Name        string
+		Last        string
+		Phase       string
+		Error       string
+		Diagnostics []diagnostic
+	}
+
+	v := vars{Prefix: prefix, Path: path, Name: name}
+	if found {
+		v.Found = true
+		v.Last = humanize.Time(data.Time)
+		v.Phase = data.Phase
+		v.Error = data.Error
+		for _, e := range data.Errors {
+			d := diagnostic{CompileError: e}
+			for i, text := range e.Source {
+				d.Lines = append(d.Lines, line{Number: e.Start + i, Text: text, Highlight: e.Start+i == e.Line})
+			}
+			v.Diagnostics = append(v.Diagnostics, d)
+		}
+	}
+
+	if err := errorsPageTemplate.Execute(w, v); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+var errorsPageTemplate = template.Must(template.New("main").Funcs(template.FuncMap{"Asset": asset}).Parse(`
+<html>
+	<head>
+		<meta charset="utf-8">
+		<link href="{{ Asset "https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0/css/bootstrap.min.css" }}" rel="stylesheet" integrity="sha384-Gn5384xqQ1aoWXA+058RXPxPg6fy4IWvTNh0E263XmFcJlSAwiGgFAW/dAiS6JXm" crossorigin="anonymous">
+		<link href="/compile.css" rel="stylesheet">
+		<style>
+			.source { text-align: left; background-color: #222; padding: 0.5em; }
+			.source .highlight { background-color: #a33; }
+		</style>
+	</head>
+	<body>
+		<div class="site-wrapper">
+			<div class="site-wrapper-inner">
+				<div class="cover-container">
+					<div class="masthead clearfix">
+						<div class="inner">
+							<h3 class="masthead-brand">jsgo</h3>
+							<nav class="nav nav-masthead">
+								<a class="nav-link" href="{{ .Prefix }}/{{ .Path }}">Compile</a>
+								<a class="nav-link active" href="">Errors</a>
+							</nav>
+						</div>
+					</div>
+					<div class="inner cover">
+						<h1 class="cover-heading">Errors</h1>
+						{{ if .Found }}
+							<p class="lead">
+								{{ .Name }} failed {{ .Last }}{{ if .Phase }} in the {{ .Phase }} phase{{ end }}
+							</p>
+							{{ range .Diagnostics }}
+								<h3><small class="text-muted">{{ .File }}:{{ .Line }}{{ if .Column }}:{{ .Column }}{{ end }}</small></h3>
+								<p>{{ .Message }}</p>
+								{{ if .Lines }}
+									<pre class="source">{{ range .Lines }}<div{{ if .Highlight }} class="highlight"{{ end }}>{{ printf "%5d" .Number }}  {{ .Text }}</div>{{ end }}</pre>
+								{{ end }}
+							{{ end }}
+							<h3><small class="text-muted">Output</small></h3>
+							<pre class="source">{{ .Error }}</pre>
+						{{ else }}
+							<p class="lead">No failed compiles of {{ .Name }} found.</p>
+						{{ end }}
+					</div>
+				</div>
+			</div>
+		</div>
+	</body>
+</html>
+`))
@@ -0,0 +1,73 @@
+package jsgo
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/jsgo/messages"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/jsgo/server/store"
+	"github.com/dave/services/session"
+)
+
+// storeFailure records the failed compile against the package, so it can be shown with ?errors.
+func (h *Handler) storeFailure(s *session.Session, name string, info messages.Compile, err error, req *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.StoreTimeout)
+	defer cancel()
+	e := servermsg.NewError(err)
+	data := store.CompileData{
+		Path:       info.Path,
+		Version:    info.Version,
+		Tags:       info.Tags,
+		MinifyOnly: info.MinifyOnly,
+		Time:       time.Now(),
+		Ip:         req.Header.Get("X-Forwarded-For"),
+		Success:    false,
+		Error:      err.Error(),
+		Phase:      e.Phase,
+	}
+	for _, p := range e.Positions {
+		data.Errors = append(data.Errors, store.CompileError{
+			Package: p.Package,
+			File:    p.File,
+			Line:    p.Line,
+			Column:  p.Column,
+			Message: p.Message,
+		})
+	}
+	addSource(s, data.Errors)
+	// Errors storing the failure are ignored - the original error is returned to the client and
+	// stored by StoreError.
+	store.StoreFailure(ctx, h.Database, name, data)
+}
+
+// sourceContext is the number of lines before and after the error included in the source.
+const sourceContext = 3
+
+// addSource adds the lines around each error from the files in the session.
+func addSource(s *session.Session, errs []store.CompileError) {
+	for i, e := range errs {
+		if e.Package == "" || e.Line == 0 {
+			continue
+		}
+		f, err := s.Filesystem(e.File).Open(e.File)
+		if err != nil {
+			continue
+		}
+		start := e.Line - sourceContext
+		if start < 1 {
+			start = 1
+		}
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan() && n <= e.Line+sourceContext; n++ {
+			if n >= start {
+				errs[i].Source = append(errs[i].Source, scanner.Text())
+			}
+		}
+		errs[i].Start = start
+		f.Close()
+	}
+}
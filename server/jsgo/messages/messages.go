@@ -1,15 +1,40 @@
 package messages
 
 import (
-	"encoding/json"
-	"reflect"
+	"time"
 
+	"github.com/dave/jsgo/server/codec"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
-	"github.com/gorilla/websocket"
+	"github.com/dave/services/builder/buildermsg"
+	"github.com/dave/services/constor/constormsg"
+	"github.com/dave/services/getter/gettermsg"
 )
 
 type Compile struct {
-	Path string
+	Path       string
+	Version    string   // Optional branch, tag or commit to build instead of the default branch
+	Tags       []string // Build tags
+	MinifyOnly bool     // Skip the unminified build
+}
+
+// Promote is sent by the client to make a previous compile (from the package history) the live index
+// page at the package path.
+type Promote struct {
+	Path    string
+	Compile int64 `json:",string"` // ID of the compile
+}
+
+// Promoted is sent when the compile has been promoted, before Complete.
+type Promoted struct {
+	Compile int64     `json:",string"`
+	Time    time.Time // Time of the compile
+}
+
+// Cached is sent instead of the download and compile progress messages when nothing in the dependency
+// tree has changed since the last compile, so the previous result is returned.
+type Cached struct {
+	Time time.Time // Time of the previous compile
 }
 
 type Complete struct {
@@ -17,30 +42,45 @@ type Complete struct {
 	Short   string
 	HashMin string
 	HashMax string
+	Modules map[string]string // Module path -> version used, when the package is in a module
+
+	// Compiles with a version, build tags or MinifyOnly don't replace the index page at the package
+	// path, so the index pages are stored by hash. HashMax and IndexMax are empty for MinifyOnly.
+	Version  string
+	Commit   string
+	IndexMin string
+	IndexMax string
 }
 
+// Codecs encodes the messages. JSON is the default, and the compile page only uses JSON.
+var Codecs = codec.NewRegistry(
+	codec.Json,
+
+	// Progress messages:
+	servermsg.Job{},
+	servermsg.Queueing{},
+	servermsg.Trace{},
+	gettermsg.Downloading{},
+	constormsg.Storing{},
+	buildermsg.Building{},
+
+	// Data messages:
+	servermsg.Error{},
+	servermsg.Cancelled{},
+	Cached{},
+	Promoted{},
+	Complete{},
+
+	// Commands:
+	servermsg.Cancel{},
+	Compile{},
+	Promote{},
+).Untyped(Compile{})
+
 func Marshal(in services.Message) ([]byte, int, error) {
-	m := struct {
-		Type    string
-		Message services.Message
-	}{
-		Type:    reflect.TypeOf(in).Name(),
-		Message: in,
-	}
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, 0, err
-	}
-	return b, websocket.TextMessage, nil
+	return Codecs.Marshal(codec.Json, in)
 }
 
 func Unmarshal(in []byte) (services.Message, error) {
-	var m struct {
-		Type    string
-		Message Compile // the jsgo compile page only ever sends Compile messages
-	}
-	if err := json.Unmarshal(in, &m); err != nil {
-		return nil, err
-	}
-	return m.Message, nil
+	return Codecs.Unmarshal(codec.Json, in)
 }
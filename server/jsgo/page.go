@@ -9,30 +9,51 @@ import (
 	"strings"
 
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/clientip"
 	"github.com/dave/jsgo/server/store"
-	"github.com/dave/services"
 	"github.com/dustin/go-humanize"
 )
 
-func Page(w http.ResponseWriter, req *http.Request, database services.Database) {
+func Page(w http.ResponseWriter, req *http.Request, database store.Database) {
 
 	ctx, cancel := context.WithTimeout(req.Context(), config.PageTimeout)
 	defer cancel()
 
-	path := normalizePath(strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/"), "/"))
+	path, version := normalizePath(strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/"), "/"))
+
+	// Build options are specified in the query e.g. ?tags=a,b&min
+	var tags []string
+	if t := req.URL.Query().Get("tags"); t != "" {
+		tags = strings.Split(t, ",")
+	}
+	_, minifyOnly := req.URL.Query()["min"]
+	name := store.PackageName(path, version, tags, minifyOnly)
 
 	if path == "" {
 		http.Redirect(w, req, "https://github.com/dave/jsgo", http.StatusFound)
 		return
 	}
 
-	var found bool
-	var data store.CompileData
+	// The page may be mounted at a prefix (see server.Router), so its links and socket URL include it.
+	prefix := strings.TrimSuffix(req.Header.Get("X-Forwarded-Prefix"), "/")
+
+	if _, ok := req.URL.Query()["errors"]; ok {
+		errorsPage(ctx, w, database, prefix, path, name)
+		return
+	}
+
+	var found, failed bool
+	var data, failure store.CompileData
 	var err error
 	if config.LOCAL {
 		found = false
 	} else {
-		found, data, err = store.Package(ctx, database, path)
+		found, data, err = store.Package(ctx, database, name)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		failed, failure, err = store.Failure(ctx, database, name)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
@@ -42,9 +63,17 @@ func Page(w http.ResponseWriter, req *http.Request, database services.Database)
 	type vars struct {
 		Found         bool
 		Path          string
+		Version       string
+		Tags          []string
+		MinifyOnly    bool
+		Commit        string
 		Last          string
+		History       []historyItem
+		Failed        string // Time of the last compile, if it failed
+		ErrorsUrl     string
 		Host          string
 		Scheme        string
+		Prefix        string
 		PkgHost       string
 		IndexHost     string
 		PkgProtocol   string
@@ -57,8 +86,20 @@ func Page(w http.ResponseWriter, req *http.Request, database services.Database)
 	v.PkgProtocol = config.Protocol[config.Pkg]
 	v.IndexProtocol = config.Protocol[config.Index]
 	v.Host = req.Host
+	v.Prefix = prefix
 	v.Path = path
-	if req.Host == config.CompileHost {
+	v.Version = version
+	v.Tags = tags
+	v.MinifyOnly = minifyOnly
+	if failed && (!found || failure.Time.After(data.Time)) {
+		v.Failed = humanize.Time(failure.Time)
+		q := req.URL.Query()
+		q.Set("errors", "")
+		v.ErrorsUrl = prefix + req.URL.Path + "?" + q.Encode()
+	}
+	// The page must use a secure websocket if it was served over https, e.g. when it's mounted at
+	// another host (see config.Pages).
+	if config.Protocol[config.Jsgo] == "https" || req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
 		v.Scheme = "wss"
 	} else {
 		v.Scheme = "ws"
@@ -66,6 +107,33 @@ func Page(w http.ResponseWriter, req *http.Request, database services.Database)
 	if found {
 		v.Found = true
 		v.Last = humanize.Time(data.Time)
+		v.Commit = data.Commit
+
+		client := clientip.Get(req)
+		ids, history, err := store.CompileHistory(ctx, database, name)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for i, d := range history {
+			item := historyItem{
+				ID:     fmt.Sprint(ids[i]),
+				Last:   humanize.Time(d.Time),
+				Commit: d.Commit,
+				Main:   d.Min.Main,
+				Index:  d.Min.Index,
+				Live:   d.Time.Equal(data.Time) && d.Min.Main == data.Min.Main,
+			}
+			// Only the default compile of a path has a live index page at the package path.
+			// Only the client that made a compile can promote it.
+			item.Promote = name == path && d.Min.Index != "" && d.Max.Index != "" && d.Client != "" && d.Client == client
+			for _, p := range d.Min.Packages {
+				if !p.Standard {
+					item.Packages = append(item.Packages, p.Path)
+				}
+			}
+			v.History = append(v.History, item)
+		}
 	}
 
 	if err := compilePageTemplate.Execute(w, v); err != nil {
@@ -74,6 +142,17 @@ func Page(w http.ResponseWriter, req *http.Request, database services.Database)
 	}
 }
 
+type historyItem struct {
+	ID       string // Compile ID (a string because the IDs are too large for JS numbers)
+	Last     string
+	Commit   string
+	Main     string
+	Index    string
+	Packages []string
+	Live     bool
+	Promote  bool
+}
+
 func asset(url string) string {
 	if config.LOCAL {
 		return "/_local" + url[strings.LastIndex(url, "/"):]
@@ -86,7 +165,7 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 	<head>
 		<meta charset="utf-8">
 		<link href="{{ Asset "https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0/css/bootstrap.min.css" }}" rel="stylesheet" integrity="sha384-Gn5384xqQ1aoWXA+058RXPxPg6fy4IWvTNh0E263XmFcJlSAwiGgFAW/dAiS6JXm" crossorigin="anonymous">
-		<link href="/compile.css" rel="stylesheet">
+		<link href="{{ .Prefix }}/compile.css" rel="stylesheet">
 	</head>
 	<body>
 		<div class="site-wrapper">
@@ -105,8 +184,11 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 					<div id="header-panel" class="inner cover">
 						<h1 class="cover-heading">Compile</h1>
 						<p class="lead">
-							{{ .Path }}
+							{{ .Path }}{{ if .Version }}@{{ .Version }}{{ end }}
 							{{ if .Found }} was compiled {{ .Last }} {{ end }}
+							{{ if .Commit }}<br><small class="text-muted">commit {{ .Commit }}</small>{{ end }}
+							{{ if .Tags }}<br><small class="text-muted">tags {{ range $i, $t := .Tags }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}</small>{{ end }}
+							{{ if .Failed }}<br><small><a href="{{ .ErrorsUrl }}">the last compile failed {{ .Failed }}</a></small>{{ end }}
 						</p>
 						<p class="lead" id="button-panel">
 							<a href="#" class="btn btn-lg btn-secondary" id="btn">Compile</a>
@@ -119,6 +201,14 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 								Complete!
 							</h1>
 
+							<p id="complete-commit" style="display: none;">
+								<small class="text-muted"></small>
+							</p>
+
+							<p id="complete-trace" style="display: none;">
+								<small class="text-muted"></small>
+							</p>
+
 							<h3><small class="text-muted">Link</small></h3>
 							<p>
 								<a id="complete-link" href=""></a>
@@ -148,6 +238,14 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 									<th scope="row" class="w-25">Queued:</th>
 									<td class="w-75"><span id="queueing-span"></span></td>
 								</tr>
+								<tr id="promoted-item" style="display: none;">
+									<th scope="row" class="w-25">Promoted:</th>
+									<td class="w-75"><span id="promoted-span">Previous compile is live</span></td>
+								</tr>
+								<tr id="cached-item" style="display: none;">
+									<th scope="row" class="w-25">Cached:</th>
+									<td class="w-75"><span id="cached-span">Unchanged since the last compile</span></td>
+								</tr>
 								<tr id="downloading-item" style="display: none;">
 									<th scope="row" class="w-25">Downloading:</th>
 									<td class="w-75"><span id="downloading-span"></span></td>
@@ -163,9 +261,41 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 							</tbody>
 						</table>
 					</div>
+					{{ if .History }}
+					<div id="history-panel" class="inner">
+						<h3><small class="text-muted">History</small></h3>
+						<table class="table table-dark table-sm">
+							<tbody>
+								{{ range .History }}
+								<tr>
+									<td>{{ .Last }}</td>
+									<td>{{ if .Commit }}<code>{{ .Commit }}</code>{{ end }}</td>
+									<td>
+										<details>
+											<summary><code>{{ .Main }}</code></summary>
+											<small>{{ range .Packages }}{{ . }}<br>{{ end }}</small>
+										</details>
+									</td>
+									<td>{{ if .Index }}<a href="{{ $.IndexProtocol }}://{{ $.IndexHost }}/{{ .Index }}">view</a>{{ end }}</td>
+									<td>
+										{{ if .Live }}
+											live
+										{{ else if .Promote }}
+											<a href="#" class="promote-link" data-compile="{{ .ID }}">promote</a>
+										{{ end }}
+									</td>
+								</tr>
+								{{ end }}
+							</tbody>
+						</table>
+					</div>
+					{{ end }}
 					<div id="error-panel" style="display: none;" class="alert alert-warning" role="alert">
 						<h4 class="alert-heading">Error</h4>
 						<pre id="error-message"></pre>
+						<p id="error-trace" style="display: none;">
+							<small class="text-muted"></small>
+						</p>
 					</div>
 				</div>
 			</div>
@@ -183,104 +313,201 @@ var compilePageTemplate = template.Must(template.New("main").Funcs(template.Func
 			var completeScript = document.getElementById("complete-script");
 			var shortUrlCheckboxHolder = document.getElementById("short-url-checkbox-holder");
 			
-			shortUrlCheckboxHolder.style.display = (final.Short == final.Path) ? "none" : "";
-			completeLink.href = "{{ .IndexProtocol }}://{{ .IndexHost }}/" + (short ? final.Short : final.Path) + (minify ? "" : "$max");
-			completeLink.innerHTML = "{{ .IndexHost }}/" + (short ? final.Short : final.Path) + (minify ? "" : "$max");
+			if (!final.HashMax) {
+				// The unminified version wasn't built
+				minify = true;
+				document.getElementById("minify-checkbox").parentElement.style.display = "none";
+			}
+			if (final.IndexMin) {
+				// Compiles with a version, tags or minify only have index pages stored by hash
+				var index = minify ? final.IndexMin : final.IndexMax;
+				shortUrlCheckboxHolder.style.display = "none";
+				completeLink.href = "{{ .IndexProtocol }}://{{ .IndexHost }}/" + index;
+				completeLink.innerHTML = "{{ .IndexHost }}/" + index;
+			} else {
+				shortUrlCheckboxHolder.style.display = (final.Short == final.Path) ? "none" : "";
+				completeLink.href = "{{ .IndexProtocol }}://{{ .IndexHost }}/" + (short ? final.Short : final.Path) + (minify ? "" : "$max");
+				completeLink.innerHTML = "{{ .IndexHost }}/" + (short ? final.Short : final.Path) + (minify ? "" : "$max");
+			}
 			completeScript.value = "{{ .PkgProtocol }}://{{ .PkgHost }}/" + final.Path + "." + (minify ? final.HashMin : final.HashMax) + ".js"
 		}
 		document.getElementById("minify-checkbox").onchange = refresh;
 		document.getElementById("short-url-checkbox").onchange = refresh;
-		document.getElementById("btn").onclick = function(event) {
-			event.preventDefault();
-			var socket = new WebSocket("{{ .Scheme }}://{{ .Host }}/_jsgo/");
-
+		var start = function(instruction) {
 			var headerPanel = document.getElementById("header-panel");
 			var buttonPanel = document.getElementById("button-panel");
 			var progressPanel = document.getElementById("progress-panel");
 			var errorPanel = document.getElementById("error-panel");
 			var completePanel = document.getElementById("complete-panel");
+			var historyPanel = document.getElementById("history-panel");
 			var errorMessage = document.getElementById("error-message");
 			
 			var done = {};
 			var complete = false;
-
-			socket.onopen = function() {
-				socket.send(JSON.stringify({
-					"Type": "Compile",
-					"Message": {
-						"Path": "{{ .Path }}"
-					}
-				}));
-				buttonPanel.style.display = "none";
-				progressPanel.style.display = "";
+			var job = "";      // job ID, sent by the server as the first message
+			var received = 0;  // number of messages received, so we can resume after a reconnect
+			var retries = 0;
+			var trace = "";    // trace ID, so users can include it in bug reports
+			var showTrace = function(id) {
+				if (!trace) {
+					return;
+				}
+				var element = document.getElementById(id);
+				element.style.display = "";
+				element.firstElementChild.textContent = "trace " + trace;
 			};
-			socket.onmessage = function (e) {
-				var payload = JSON.parse(e.data)
-				switch (payload.Type) {
-				case "Queueing":
-				case "Downloading":
-				case "Compiling":
-				case "Storing":
-					if (done[payload.Type]) {
-						// Messages might arrive out of order... Once we get a "done", ignore 
-						// any more.
-						break;
+
+			var connect = function() {
+				var url = "{{ .Scheme }}://{{ .Host }}{{ .Prefix }}/_jsgo/";
+				if (job) {
+					url += "?job=" + job + "&from=" + received;
+				}
+				// Version 1 of the messages includes the job ID, so the page can reconnect.
+				var socket = new WebSocket(url, "json.v1");
+				socket.onopen = function() {
+					if (job) {
+						// We're resuming an existing job, so we don't need to send the instruction again.
+						return;
 					}
-					var item = document.getElementById(payload.Type.toLowerCase()+"-item");
-					var span = document.getElementById(payload.Type.toLowerCase()+"-span");
-					item.style.display = "";
-					if (payload.Message.Done) {
-						span.innerHTML = "Done";
-						done[payload.Type] = true;
-					} else if (payload.Message.Starting) {
-						span.innerHTML = "Starting";
-					} else if (payload.Message.Message) {
-						span.innerHTML = payload.Message.Message;
-					} else if (payload.Message.Position) {
-						span.innerHTML = "Position " + payload.Message.Position;
-					} else if (payload.Message.Finished !== undefined) {
-						span.innerHTML = payload.Message.Finished + " finished, " + payload.Message.Unchanged + " unchanged, " + payload.Message.Remain + " remain.";
-					} else {
-						span.innerHTML = "Starting";
+					socket.send(JSON.stringify(instruction));
+					buttonPanel.style.display = "none";
+					if (historyPanel) {
+						historyPanel.style.display = "none";
 					}
-					break;
-				case "Complete":
-					complete = true;
-					final = payload.Message;
-					completePanel.style.display = "";
-					progressPanel.style.display = "none";
-					headerPanel.style.display = "none";
-					refresh();
-					break;
-				case "Error":
-					if (complete) {
+					progressPanel.style.display = "";
+				};
+				socket.onmessage = function (e) {
+					var payload = JSON.parse(e.data)
+					received++;
+					retries = 0;
+					switch (payload.Type) {
+					case "Job":
+						job = payload.Message.ID;
+						break;
+					case "Trace":
+						trace = payload.Message.ID;
+						break;
+					case "Queueing":
+					case "Downloading":
+					case "Compiling":
+					case "Storing":
+						if (done[payload.Type]) {
+							// Messages might arrive out of order... Once we get a "done", ignore 
+							// any more.
+							break;
+						}
+						var item = document.getElementById(payload.Type.toLowerCase()+"-item");
+						var span = document.getElementById(payload.Type.toLowerCase()+"-span");
+						item.style.display = "";
+						if (payload.Message.Done) {
+							span.innerHTML = "Done";
+							done[payload.Type] = true;
+						} else if (payload.Message.Starting) {
+							span.innerHTML = "Starting";
+						} else if (payload.Message.Message) {
+							span.innerHTML = payload.Message.Message;
+						} else if (payload.Message.Position) {
+							span.innerHTML = "Position " + payload.Message.Position;
+							if (payload.Message.Wait) {
+								span.innerHTML += " (about " + payload.Message.Wait + "s)";
+							}
+						} else if (payload.Message.Finished !== undefined) {
+							span.innerHTML = payload.Message.Finished + " finished, " + payload.Message.Unchanged + " unchanged, " + payload.Message.Remain + " remain.";
+						} else {
+							span.innerHTML = "Starting";
+						}
+						break;
+					case "Cached":
+						document.getElementById("cached-item").style.display = "";
+						break;
+					case "Promoted":
+						document.getElementById("promoted-item").style.display = "";
+						break;
+					case "Complete":
+						complete = true;
+						final = payload.Message;
+						if (final.Commit) {
+							var commit = document.getElementById("complete-commit");
+							commit.style.display = "";
+							commit.firstElementChild.textContent = final.Version + " (commit " + final.Commit + ")";
+						}
+						showTrace("complete-trace");
+						completePanel.style.display = "";
+						progressPanel.style.display = "none";
+						headerPanel.style.display = "none";
+						refresh();
+						break;
+					case "Error":
+						if (complete) {
+							break;
+						}
+						complete = true;
+						errorPanel.style.display = "";
+						errorMessage.innerHTML = payload.Message.Message;
+						showTrace("error-trace");
 						break;
 					}
-					complete = true;
-					errorPanel.style.display = "";
-					errorMessage.innerHTML = payload.Message.Message;
-					break;
-				}
+				};
 				socket.onclose = function() {
 					if (complete) {
 						return;
 					}
+					if (job && retries < 5) {
+						// The connection dropped before the job finished... reconnect and resume.
+						retries++;
+						setTimeout(connect, 1000 * retries);
+						return;
+					}
 					errorPanel.style.display = "";
 					errorMessage.innerHTML = "server disconnected";
+					showTrace("error-trace");
+				};
+			};
+			connect();
+		};
+		document.getElementById("btn").onclick = function(event) {
+			event.preventDefault();
+			start({
+				"Type": "Compile",
+				"Message": {
+					"Path": "{{ .Path }}",
+					"Version": "{{ .Version }}",
+					"Tags": {{ .Tags }},
+					"MinifyOnly": {{ .MinifyOnly }}
 				}
-			}
+			});
 		};
+		var promote = document.getElementsByClassName("promote-link");
+		for (var i = 0; i < promote.length; i++) {
+			promote[i].onclick = function(event) {
+				event.preventDefault();
+				start({
+					"Type": "Promote",
+					"Message": {
+						"Path": "{{ .Path }}",
+						"Compile": this.getAttribute("data-compile")
+					}
+				});
+			};
+		}
 	</script>
 </html>
 `))
 
-func normalizePath(path string) string {
+// normalizePath returns the package path and the version (branch, tag or commit) if one was specified
+// with @ (e.g. github.com/foo/bar@v1.2.0).
+func normalizePath(path string) (string, string) {
+
+	var version string
+	if i := strings.LastIndex(path, "@"); i > -1 {
+		path, version = path[:i], path[i+1:]
+	}
 
 	// We should normalize gist urls by removing the username part
 	if strings.HasPrefix(path, "gist.github.com/") {
 		matches := gistWithUsername.FindStringSubmatch(path)
 		if len(matches) > 1 {
-			return fmt.Sprintf("gist.github.com/%s", matches[1])
+			return fmt.Sprintf("gist.github.com/%s", matches[1]), version
 		}
 	}
 
@@ -288,11 +515,11 @@ func normalizePath(path string) string {
 	if strings.Contains(path, "/") {
 		firstPart := path[:strings.Index(path, "/")]
 		if !strings.Contains(firstPart, ".") && githubUsername.MatchString(firstPart) {
-			return fmt.Sprintf("github.com/%s", path)
+			return fmt.Sprintf("github.com/%s", path), version
 		}
 	}
 
-	return path
+	return path, version
 }
 
 var gistWithUsername = regexp.MustCompile(`^gist\.github\.com/[A-Za-z0-9_.\-]+/([a-f0-9]+)(/[\p{L}0-9_.\-]+)*$`)
@@ -0,0 +1,125 @@
+package jsgo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/clientip"
+	"github.com/dave/jsgo/server/jsgo/messages"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/jsgo/server/store"
+	"github.com/dave/services"
+	"github.com/dave/services/constor"
+	"github.com/dave/services/deployer"
+)
+
+// Promote makes a previous compile of a package the live index page at the package path, without
+// recompiling. Only the client that made the compile can promote it.
+func (h *Handler) Promote(ctx context.Context, info messages.Promote, req *http.Request, send func(services.Message), receive chan services.Message) error {
+
+	found, data, err := store.Compile(ctx, h.Database, info.Compile)
+	if err != nil {
+		return servermsg.Failed(servermsg.PhaseStore, info.Path, err)
+	}
+	if !found || !data.Success || data.Path != info.Path {
+		return servermsg.Invalid(fmt.Errorf("compile %d of %s not found", info.Compile, info.Path))
+	}
+	if store.PackageName(data.Path, data.Version, data.Tags, data.MinifyOnly) != data.Path {
+		return servermsg.Invalid(fmt.Errorf("compile %d of %s has a version or build options, so it can't be promoted", info.Compile, info.Path))
+	}
+	if data.Client == "" || data.Client != clientip.Get(req) {
+		return servermsg.Invalid(fmt.Errorf("compile %d of %s was made by another client, so it can't be promoted", info.Compile, info.Path))
+	}
+	if data.Min.Index == "" || data.Max.Index == "" {
+		return servermsg.Invalid(fmt.Errorf("compile %d of %s was made before history was recorded, so it can't be promoted", info.Compile, info.Path))
+	}
+
+	storer := constor.New(ctx, h.Fileserver, send, config.ConcurrentStorageUploads)
+	defer storer.Close()
+
+	for min, hash := range map[bool]string{true: data.Min.Index, false: data.Max.Index} {
+		buf := &bytes.Buffer{}
+		found, err := h.Fileserver.Read(ctx, config.Bucket[config.Index], hash, buf)
+		if err != nil {
+			return servermsg.Failed(servermsg.PhaseStore, info.Path, err)
+		}
+		if !found {
+			return servermsg.Failed(servermsg.PhaseStore, info.Path, fmt.Errorf("index page %s not found", hash))
+		}
+		for _, name := range indexNames(data.Path, min) {
+			storer.Add(constor.Item{
+				Name:     name,
+				Contents: buf.Bytes(),
+				Bucket:   config.Bucket[config.Index],
+				Mime:     constor.MimeHtml,
+			})
+		}
+	}
+	if err := storer.Wait(); err != nil {
+		return servermsg.Failed(servermsg.PhaseStore, info.Path, err)
+	}
+
+	if err := store.Promote(ctx, h.Database, data.Path, data); err != nil {
+		return servermsg.Failed(servermsg.PhaseStore, info.Path, err)
+	}
+
+	send(messages.Promoted{Compile: info.Compile, Time: data.Time})
+	send(messages.Complete{
+		Path:    data.Path,
+		Short:   strings.TrimPrefix(data.Path, "github.com/"),
+		HashMin: data.Min.Main,
+		HashMax: data.Max.Main,
+		Modules: modulesMap(data.Modules),
+		Version: data.Version,
+		Commit:  data.Commit,
+	})
+	return nil
+}
+
+// archiveIndex stores a copy of the index pages at the package path by hash, so the compile can be
+// promoted after it's been replaced by a later compile.
+func (h *Handler) archiveIndex(ctx context.Context, send func(services.Message), path string, output map[bool]*deployer.DeployOutput) error {
+	storer := constor.New(ctx, h.Fileserver, send, config.ConcurrentStorageUploads)
+	defer storer.Close()
+	for min, o := range output {
+		buf := &bytes.Buffer{}
+		name := indexNames(path, min)[0]
+		found, err := h.Fileserver.Read(ctx, config.Bucket[config.Index], name, buf)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("index page %s not found", name)
+		}
+		hash := fmt.Sprintf("%x", o.IndexHash)
+		for _, name := range []string{hash, hash + "/index.html"} {
+			storer.Add(constor.Item{
+				Name:      name,
+				Contents:  buf.Bytes(),
+				Bucket:    config.Bucket[config.Index],
+				Mime:      constor.MimeHtml,
+				Immutable: true,
+			})
+		}
+	}
+	return storer.Wait()
+}
+
+// indexNames returns the names of the index page at the package path in the index bucket (the same as
+// deployer.PathIndex).
+func indexNames(path string, min bool) []string {
+	full := path
+	if !min {
+		full += "$max"
+	}
+	short := strings.TrimPrefix(full, "github.com/")
+	names := []string{short, short + "/index.html"}
+	if short != full {
+		names = append(names, full, full+"/index.html")
+	}
+	return names
+}
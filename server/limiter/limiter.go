@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dave/jsgo/config"
+)
+
+// New creates a set of limiters from the policies, which are keyed by route.
+func New(policies map[string]config.RateLimitPolicy) *Set {
+	s := &Set{routes: map[string]*route{}}
+	for name, p := range policies {
+		s.routes[name] = &route{
+			client: newLimiter(p.Client),
+			path:   newLimiter(p.Path),
+		}
+	}
+	return s
+}
+
+// Set is a collection of token bucket rate limiters, with a per-client and a per-client-and-path
+// limiter for each route.
+type Set struct {
+	routes map[string]*route
+}
+
+type route struct {
+	client, path *limiter
+}
+
+// AllowClient takes a token from the client bucket for the route. If the bucket is empty, ok is false
+// and retry is the time until a token will be available.
+func (s *Set) AllowClient(route, client string) (ok bool, retry time.Duration) {
+	r, found := s.routes[route]
+	if !found {
+		return true, 0
+	}
+	return r.client.allow(client)
+}
+
+// AllowPath takes a token from the bucket of the client and package path for the route. If the bucket
+// is empty, ok is false and retry is the time until a token will be available.
+func (s *Set) AllowPath(route, client, path string) (ok bool, retry time.Duration) {
+	r, found := s.routes[route]
+	if !found || path == "" {
+		return true, 0
+	}
+	return r.path.allow(client + " " + path)
+}
+
+func newLimiter(policy config.RateLimit) *limiter {
+	return &limiter{
+		rate:    policy.Rate,
+		burst:   float64(policy.Burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+type limiter struct {
+	sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64 // maximum tokens in a bucket
+	buckets map[string]*bucket
+	calls   int
+	now     func() time.Time // time.Now, except in tests
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		// a zero rate disables the limiter
+		return true, 0
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+
+	l.calls++
+	if l.calls%1000 == 0 {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune removes buckets that would have refilled completely, so the map doesn't grow forever.
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+)
+
+// clock is a fake time.Now for the limiters.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestSet(c *clock) *Set {
+	s := New(map[string]config.RateLimitPolicy{
+		"a": {
+			Client: config.RateLimit{Rate: 1, Burst: 3},
+			Path:   config.RateLimit{Rate: 0.5, Burst: 1},
+		},
+	})
+	for _, r := range s.routes {
+		r.client.now = c.now
+		r.path.now = c.now
+	}
+	return s
+}
+
+func TestBurst(t *testing.T) {
+	s := newTestSet(&clock{t: time.Now()})
+	for i := 0; i < 3; i++ {
+		if ok, _ := s.AllowClient("a", "1"); !ok {
+			t.Fatalf("request %d: expected the burst to be allowed", i)
+		}
+	}
+	ok, retry := s.AllowClient("a", "1")
+	if ok {
+		t.Fatal("expected the request after the burst to be limited")
+	}
+	if retry != time.Second {
+		t.Fatalf("expected to retry after 1s, got %v", retry)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	c := &clock{t: time.Now()}
+	s := newTestSet(c)
+	for i := 0; i < 3; i++ {
+		s.AllowClient("a", "1")
+	}
+	c.t = c.t.Add(time.Millisecond * 1500)
+	if ok, _ := s.AllowClient("a", "1"); !ok {
+		t.Fatal("expected a token after 1.5s")
+	}
+	if ok, retry := s.AllowClient("a", "1"); ok || retry != time.Millisecond*500 {
+		t.Fatalf("expected to retry after 500ms, got %v, %v", ok, retry)
+	}
+
+	// The bucket never holds more than the burst.
+	c.t = c.t.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := s.AllowClient("a", "1"); !ok {
+			t.Fatalf("request %d: expected the bucket to be full", i)
+		}
+	}
+	if ok, _ := s.AllowClient("a", "1"); ok {
+		t.Fatal("expected the bucket to be limited to the burst")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s := newTestSet(&clock{t: time.Now()})
+	for i := 0; i < 3; i++ {
+		s.AllowClient("a", "1")
+	}
+	if ok, _ := s.AllowClient("a", "2"); !ok {
+		t.Fatal("expected another client to have its own bucket")
+	}
+
+	// The path bucket is per client, so one client can't lock another out of a package.
+	if ok, _ := s.AllowPath("a", "1", "p"); !ok {
+		t.Fatal("expected the first request for the path to be allowed")
+	}
+	if ok, _ := s.AllowPath("a", "1", "p"); ok {
+		t.Fatal("expected the second request for the path to be limited")
+	}
+	if ok, _ := s.AllowPath("a", "2", "p"); !ok {
+		t.Fatal("expected another client to be allowed the same path")
+	}
+	if ok, _ := s.AllowPath("a", "1", "q"); !ok {
+		t.Fatal("expected another path to have its own bucket")
+	}
+
+	// Routes without a policy, and routes with a zero rate, aren't limited.
+	for i := 0; i < 10; i++ {
+		if ok, _ := s.AllowClient("b", "1"); !ok {
+			t.Fatal("expected a route without a policy to be allowed")
+		}
+	}
+	z := New(map[string]config.RateLimitPolicy{"a": {}})
+	for i := 0; i < 10; i++ {
+		if ok, _ := z.AllowClient("a", "1"); !ok {
+			t.Fatal("expected a zero rate to be allowed")
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := &clock{t: time.Now()}
+	s := newTestSet(c)
+	l := s.routes["a"].client
+	s.AllowClient("a", "1")
+	c.t = c.t.Add(time.Hour)
+	l.prune(c.now())
+	if len(l.buckets) != 0 {
+		t.Fatalf("expected the full bucket to be removed, got %d", len(l.buckets))
+	}
+}
@@ -0,0 +1,109 @@
+// Package listener serves a handler on several addresses (see config.Listener) and shuts them down
+// together.
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/logger"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// New creates a group of servers for the listeners. Call Start to start them.
+func New(listeners []config.Listener, handler http.Handler, log *logger.Logger) *Group {
+	g := &Group{log: log}
+	for _, l := range listeners {
+		s := &http.Server{Addr: l.Addr, Handler: handler}
+		if l.H2C {
+			// HTTP/1 requests are passed through to the handler.
+			s.Handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		g.servers = append(g.servers, &server{http: s, listener: l})
+	}
+	return g
+}
+
+// Group is the servers of a handler, one for each listener.
+type Group struct {
+	servers []*server
+	log     *logger.Logger
+}
+
+type server struct {
+	http     *http.Server
+	listener config.Listener
+	addr     net.Addr // The address listened on, after Start
+}
+
+// Start listens on all the addresses, then serves them in the background. If any address can't be
+// listened on, none are served. Errors from the servers (other than http.ErrServerClosed) are sent to
+// the channel.
+func (g *Group) Start() (<-chan error, error) {
+	var listeners []net.Listener
+	for _, s := range g.servers {
+		ln, err := net.Listen("tcp", s.listener.Addr)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		s.addr = ln.Addr()
+		listeners = append(listeners, ln)
+	}
+	errs := make(chan error, len(g.servers))
+	for i, s := range g.servers {
+		go func(s *server, ln net.Listener) {
+			g.log.Info("listening", "addr", s.addr.String(), "tls", s.listener.CertFile != "", "h2c", s.listener.H2C)
+			var err error
+			if s.listener.CertFile != "" {
+				err = s.http.ServeTLS(ln, s.listener.CertFile, s.listener.KeyFile)
+			} else {
+				err = s.http.Serve(ln)
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(s, listeners[i])
+	}
+	return errs, nil
+}
+
+// Addrs returns the addresses listened on, after Start (e.g. the port chosen for ":0").
+func (g *Group) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, s := range g.servers {
+		addrs = append(addrs, s.addr)
+	}
+	return addrs
+}
+
+// Shutdown gracefully shuts down all the servers concurrently, and returns the first error.
+func (g *Group) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(g.servers))
+	for i, s := range g.servers {
+		wg.Add(1)
+		go func(i int, s *server) {
+			defer wg.Done()
+			if err := s.http.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", s.listener.Addr, err)
+				return
+			}
+			g.log.Info("server stopped", "addr", s.listener.Addr)
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package listener
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/logger"
+	"golang.org/x/net/http2"
+)
+
+func TestGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := writeCert(t, dir)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Proto)
+	})
+	g := New([]config.Listener{
+		{Addr: "127.0.0.1:0"},
+		{Addr: "127.0.0.1:0", H2C: true},
+		{Addr: "127.0.0.1:0", CertFile: cert, KeyFile: key},
+	}, handler, logger.New(ioutil.Discard, logger.Error))
+	errs, err := g.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := g.Addrs()
+
+	// The h2c transport dials plain TCP instead of TLS.
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	secure := &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	for _, test := range []struct {
+		url       string
+		transport http.RoundTripper
+		proto     string
+	}{
+		{"http://" + addrs[0].String(), &http.Transport{}, "HTTP/1.1"},
+		{"http://" + addrs[1].String(), h2c, "HTTP/2.0"},
+		{"https://" + addrs[2].String(), secure, "HTTP/2.0"},
+	} {
+		resp, err := (&http.Client{Transport: test.transport}).Get(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != test.proto {
+			t.Errorf("%s: got %s, expected %s", test.url, b, test.proto)
+		}
+	}
+
+	// A second group can't listen on the same addresses.
+	if _, err := New([]config.Listener{{Addr: "127.0.0.1:0"}, {Addr: addrs[0].String()}}, handler, logger.Default).Start(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	h2c.CloseIdleConnections()
+	secure.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := g.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+	if _, err := net.Dial("tcp", addrs[0].String()); err == nil {
+		t.Fatal("expected the server to be closed")
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key.
+func writeCert(t *testing.T, dir string) (cert, key string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
@@ -0,0 +1,165 @@
+// Package logger writes structured log entries as JSON, one per line.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levels = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levels[l]
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levels {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Default is used when no logger has been injected (e.g. by the assets and initialise packages).
+var Default = New(os.Stderr, Info)
+
+// Logger writes entries at or above its level. Entries include the fields of the logger followed by
+// the fields of the entry.
+type Logger struct {
+	out    *output
+	level  Level
+	fields []interface{}
+}
+
+type output struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// New creates a logger that writes to w.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &output{w: w}, level: level}
+}
+
+// With returns a logger that adds the fields (key / value pairs) to every entry.
+func (l *Logger) With(fields ...interface{}) *Logger {
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		fields: append(append([]interface{}(nil), l.fields...), fields...),
+	}
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) { l.log(Debug, msg, fields) }
+func (l *Logger) Info(msg string, fields ...interface{})  { l.log(Info, msg, fields) }
+func (l *Logger) Warn(msg string, fields ...interface{})  { l.log(Warn, msg, fields) }
+func (l *Logger) Error(msg string, fields ...interface{}) { l.log(Error, msg, fields) }
+
+// Fatal writes an error entry and exits.
+func (l *Logger) Fatal(msg string, fields ...interface{}) {
+	l.log(Error, msg, fields)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, fields []interface{}) {
+	if level < l.level {
+		return
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	field(buf, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(",")
+	field(buf, "level", level.String())
+	buf.WriteString(",")
+	field(buf, "msg", msg)
+	all := append(append([]interface{}(nil), l.fields...), fields...)
+	for i := 0; i+1 < len(all); i += 2 {
+		buf.WriteString(",")
+		field(buf, fmt.Sprint(all[i]), all[i+1])
+	}
+	buf.WriteString("}\n")
+	l.out.Lock()
+	defer l.out.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// field writes a key / value pair. Errors are written as their message and durations in seconds.
+func field(buf *bytes.Buffer, key string, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.Seconds()
+	}
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(k)
+	buf.WriteString(":")
+	buf.Write(v)
+}
+
+type loggerKey struct{}
+
+// NewContext returns a context that carries the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger in the context, or fallback if there isn't one.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// Init configures Default from the LOG_LEVEL ("debug", "info", "warn" or "error") and LOG_OUTPUT
+// ("stderr", "stdout" or a file name) environment variables.
+func Init() error {
+	level := Info
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		var err error
+		if level, err = ParseLevel(s); err != nil {
+			return err
+		}
+	}
+	var w io.Writer = os.Stderr
+	switch s := os.Getenv("LOG_OUTPUT"); s {
+	case "", "stderr":
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(s, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	Default = New(w, level)
+	return nil
+}
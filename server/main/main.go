@@ -1,8 +1,7 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"flag"
 	"os"
 
 	"github.com/dave/jsgo/config"
@@ -10,58 +9,78 @@ import (
 	"context"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/dave/jsgo/server"
+	"github.com/dave/jsgo/server/listener"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/s3"
+	"github.com/dave/jsgo/server/sqldatabase"
+	"github.com/dave/jsgo/server/trace"
 )
 
 func main() {
 
-	var mainServer, dev1Server, dev2Server, dev3Server *http.Server
+	// The log level and output are set by LOG_LEVEL and LOG_OUTPUT.
+	if err := logger.Init(); err != nil {
+		logger.Default.Fatal("configuring logger", "error", err)
+	}
 
-	shutdown := make(chan struct{})
-	handler := server.New(shutdown)
-
-	if config.DEV {
-		mainServer = &http.Server{Addr: ":8080", Handler: handler}
-		dev1Server = &http.Server{Addr: ":8081", Handler: handler}
-		dev2Server = &http.Server{Addr: ":8082", Handler: handler}
-		dev3Server = &http.Server{Addr: ":8083", Handler: handler}
-	} else {
-		port := "8080"
-		if fromEnv := os.Getenv("PORT"); fromEnv != "" {
-			port = fromEnv
+	// The configuration is the preset, overridden by the config file and the environment (see
+	// config.Load). The dev and local build tags only choose the default preset.
+	configFile := flag.String("config", os.Getenv("JSGO_CONFIG"), "JSON config file")
+	preset := flag.String("preset", "", "config preset (prod, dev or local)")
+	printConfig := flag.Bool("print-config", false, "print the config and exit")
+	flag.Parse()
+	c, err := config.Load(*preset, *configFile)
+	if err != nil {
+		logger.Default.Fatal("loading config", "error", err)
+	}
+	if *printConfig {
+		if err := c.Print(os.Stdout); err != nil {
+			logger.Default.Fatal("printing config", "error", err)
 		}
-		mainServer = &http.Server{Addr: ":" + port, Handler: handler}
+		return
+	}
+	config.Apply(c)
+
+	// Spans are exported to an OTLP collector (e.g. OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318)
+	// or appended to a file.
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		trace.SetExporter(trace.NewOtlp(endpoint))
+	} else if name := os.Getenv("TRACE_FILE"); name != "" {
+		exporter, err := trace.NewFile(name)
+		if err != nil {
+			logger.Default.Fatal("opening trace file", "error", err)
+		}
+		trace.SetExporter(exporter)
 	}
 
-	go func() {
-		log.Print("Listening on " + mainServer.Addr)
-		if err := mainServer.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatal(err)
+	shutdown := make(chan struct{})
+	// Files are stored in an S3-compatible object store (e.g. MinIO) instead of GCS if S3_ENDPOINT is set.
+	options := server.Options{Objects: s3.FromEnv()}
+
+	// Entities are stored in SQLite or Postgres instead of the cloud datastore if DATABASE_DRIVER
+	// ("sqlite3" or "postgres") is set. The server must be built with the sqlite or postgres tag.
+	if driver := os.Getenv("DATABASE_DRIVER"); driver != "" {
+		database, err := sqldatabase.Open(context.Background(), driver, os.Getenv("DATABASE_URL"), config.Kinds)
+		if err != nil {
+			logger.Default.Fatal("opening database", "error", err)
 		}
-	}()
+		options.Database = database
+	}
+
+	handler := server.New(shutdown, options)
 
-	if config.DEV {
-		go func() {
-			log.Print("Listening on " + dev1Server.Addr)
-			if err := dev1Server.ListenAndServe(); err != http.ErrServerClosed {
-				log.Fatal(err)
-			}
-		}()
-
-		go func() {
-			log.Print("Listening on " + dev2Server.Addr)
-			if err := dev2Server.ListenAndServe(); err != http.ErrServerClosed {
-				log.Fatal(err)
-			}
-		}()
-
-		go func() {
-			log.Print("Listening on " + dev3Server.Addr)
-			if err := dev3Server.ListenAndServe(); err != http.ErrServerClosed {
-				log.Fatal(err)
-			}
-		}()
+	// The admin dashboard at /_admin/ is disabled unless ADMIN_TOKEN is set.
+	handler.Admin.Token = os.Getenv("ADMIN_TOKEN")
+
+	// The server listens on each of config.Listeners: the dev and local presets serve each page on its
+	// own port, and prod listens on PORT.
+	servers := listener.New(config.Listeners, handler, logger.Default)
+	errs, err := servers.Start()
+	if err != nil {
+		logger.Default.Fatal("listening failed", "error", err)
 	}
 
 	// Set up graceful shutdown
@@ -69,41 +88,44 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	// Wait for shutdown signal
-	<-stop
-
-	// Signal to all the compile handlers that the server wants to shut down
-	close(shutdown)
-
-	ctx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout)
-	defer cancel()
+	select {
+	case <-stop:
+	case err := <-errs:
+		logger.Default.Fatal("server failed", "error", err)
+	}
 
-	// Wait for all compile jobs to be cancelled
-	handler.Waitgroup.Wait()
+	// Stop admitting new jobs and fail the health check, so clients are sent to other instances
+	handler.Drain()
 
-	if err := mainServer.Shutdown(ctx); err != nil {
-		log.Printf("Error: %v\n", err)
-	} else {
-		log.Println("Main server stopped")
+	// Wait for the running jobs to finish
+	drained := make(chan struct{})
+	go func() {
+		handler.Waitgroup.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logger.Default.Info("drained")
+	case <-time.After(config.DrainTimeout):
+		// Signal to all the compile handlers that the server wants to shut down
+		close(shutdown)
+
+		// Wait for all compile jobs to be cancelled
+		select {
+		case <-drained:
+		case <-time.After(config.CancelTimeout):
+			logger.Default.Error("jobs still running after cancel")
+		}
 	}
 
-	if config.DEV {
-		if err := dev1Server.Shutdown(ctx); err != nil {
-			log.Printf("Error: %v\n", err)
-		} else {
-			log.Println("Dev 1 server stopped")
-		}
+	// Export the spans of the last jobs
+	trace.Flush()
 
-		if err := dev2Server.Shutdown(ctx); err != nil {
-			log.Printf("Error: %v\n", err)
-		} else {
-			log.Println("Dev 2 server stopped")
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout)
+	defer cancel()
 
-		if err := dev3Server.Shutdown(ctx); err != nil {
-			log.Printf("Error: %v\n", err)
-		} else {
-			log.Println("Dev 3 server stopped")
-		}
+	// Shut down all the servers concurrently
+	if err := servers.Shutdown(ctx); err != nil {
+		logger.Default.Error("server shutdown failed", "error", err)
 	}
-
 }
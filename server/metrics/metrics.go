@@ -0,0 +1,210 @@
+// Package metrics exports counters, gauges and histograms in the Prometheus text format at /metrics.
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/dave/services/builder"
+	"github.com/dave/services/builder/buildermsg"
+	"github.com/dave/services/constor/constormsg"
+	"github.com/dave/services/getter/gettermsg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DurationBuckets are the histogram buckets for durations in seconds.
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jsgo_queue_depth",
+		Help: "Jobs waiting in the queue.",
+	}, []string{"lane"})
+	QueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jsgo_queue_wait_seconds",
+		Help:    "Time jobs waited in the queue.",
+		Buckets: DurationBuckets,
+	}, []string{"lane"})
+	PhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jsgo_phase_duration_seconds",
+		Help:    "Duration of each phase of a job.",
+		Buckets: DurationBuckets,
+	}, []string{"lane", "phase"})
+	StoredFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsgo_stored_files_total",
+		Help: "Files written to the fileserver.",
+	}, []string{"bucket", "result"})
+	StoredBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsgo_stored_bytes_total",
+		Help: "Bytes written to the fileserver.",
+	}, []string{"bucket"})
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsgo_cache_requests_total",
+		Help: "Lookups of precompiled std archives and git hints.",
+	}, []string{"cache", "result"})
+	Connections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jsgo_websocket_connections",
+		Help: "Open websocket connections.",
+	}, []string{"lane"})
+	ConnectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsgo_websocket_connections_total",
+		Help: "Websocket connections opened.",
+	}, []string{"lane"})
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsgo_errors_total",
+		Help: "Errors sent to clients.",
+	}, []string{"lane", "code"})
+)
+
+var handler = promhttp.Handler()
+
+// Handler serves the metrics of the default Prometheus registry (including the Go runtime and process
+// metrics) in the text format.
+func Handler(w http.ResponseWriter, req *http.Request) {
+	handler.ServeHTTP(w, req)
+}
+
+// Job records the metrics of a job from the messages it sends.
+type Job struct {
+	lane  string
+	m     sync.Mutex
+	phase string
+	start time.Time
+}
+
+// NewJob records the metrics of a job in the lane. End must be called when the job finishes.
+func NewJob(lane string) *Job {
+	return &Job{lane: lane}
+}
+
+// Send wraps the send function of the job. The progress messages mark the start of each phase, and
+// errors are counted by code.
+func (j *Job) Send(send func(services.Message)) func(services.Message) {
+	return func(message services.Message) {
+		switch message := message.(type) {
+		case gettermsg.Downloading:
+			j.enter(servermsg.PhaseDownload)
+		case buildermsg.Building:
+			j.enter(servermsg.PhaseBuild)
+		case constormsg.Storing:
+			j.enter(servermsg.PhaseStore)
+		case servermsg.Error:
+			Errors.WithLabelValues(j.lane, message.Code).Inc()
+		}
+		send(message)
+	}
+}
+
+func (j *Job) enter(phase string) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if phase == j.phase {
+		return
+	}
+	j.observe()
+	j.phase, j.start = phase, time.Now()
+}
+
+// observe records the duration of the current phase. Must be called with the lock held.
+func (j *Job) observe() {
+	if j.phase != "" {
+		PhaseDuration.WithLabelValues(j.lane, j.phase).Observe(time.Since(j.start).Seconds())
+	}
+}
+
+// End records the duration of the last phase.
+func (j *Job) End() {
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.observe()
+	j.phase = ""
+}
+
+// StdArchives counts the standard library packages in a build that used the precompiled archives
+// (hits) and the ones that were compiled from source (misses).
+func StdArchives(packages []*builder.PackageOutput) {
+	for _, p := range packages {
+		if !p.Standard {
+			continue
+		}
+		if p.Store {
+			CacheRequests.WithLabelValues("std", "miss").Inc()
+		} else {
+			CacheRequests.WithLabelValues("std", "hit").Inc()
+		}
+	}
+}
+
+// NewFileserver wraps a fileserver to count the files and bytes written.
+func NewFileserver(fileserver services.Fileserver) *Fileserver {
+	return &Fileserver{Fileserver: fileserver}
+}
+
+type Fileserver struct {
+	services.Fileserver
+}
+
+func (f *Fileserver) Write(ctx context.Context, bucket, name string, reader io.Reader, overwrite bool, contentType, cacheControl string) (saved bool, err error) {
+	r := &countingReader{Reader: reader}
+	saved, err = f.Fileserver.Write(ctx, bucket, name, r, overwrite, contentType, cacheControl)
+	switch {
+	case err != nil:
+		StoredFiles.WithLabelValues(bucket, "error").Inc()
+	case saved:
+		StoredFiles.WithLabelValues(bucket, "saved").Inc()
+		StoredBytes.WithLabelValues(bucket).Add(float64(r.n))
+	default:
+		StoredFiles.WithLabelValues(bucket, "unchanged").Inc()
+	}
+	return saved, err
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// NewDatabase wraps the database used by the getter cache to count git hints lookups of the kind.
+func NewDatabase(database services.Database, hintsKind string) *Database {
+	return &Database{Database: database, hintsKind: hintsKind}
+}
+
+type Database struct {
+	services.Database
+	hintsKind string
+}
+
+func (d *Database) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	err := d.Database.GetMulti(ctx, keys, dst)
+	multi, _ := err.(datastore.MultiError)
+	for i, key := range keys {
+		if key.Kind != d.hintsKind {
+			continue
+		}
+		switch {
+		case err == nil:
+			CacheRequests.WithLabelValues("hints", "hit").Inc()
+		case err == datastore.ErrNoSuchEntity:
+			CacheRequests.WithLabelValues("hints", "miss").Inc()
+		case multi != nil && multi[i] == nil:
+			CacheRequests.WithLabelValues("hints", "hit").Inc()
+		case multi != nil && multi[i] == datastore.ErrNoSuchEntity:
+			CacheRequests.WithLabelValues("hints", "miss").Inc()
+		}
+	}
+	return err
+}
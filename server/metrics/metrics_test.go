@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+)
+
+func TestHandler(t *testing.T) {
+	job := NewJob("test")
+	send := job.Send(func(services.Message) {})
+	send(servermsg.Error{Code: "test"})
+	job.End()
+	QueueDepth.WithLabelValues("test").Inc()
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{
+		"# TYPE jsgo_errors_total counter\n",
+		`jsgo_errors_total{code="test",lane="test"} 1`,
+		"# TYPE jsgo_queue_depth gauge\n",
+		`jsgo_queue_depth{lane="test"} 1`,
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GoMod is the parts of a go.mod file we need to resolve the build list.
+type GoMod struct {
+	Module  string
+	Require []Module
+	Replace map[Module]Module // Keys with an empty Version replace all versions. Replacements with an empty Version are directories.
+}
+
+// ParseGoMod parses a go.mod file. Exclude and go directives are ignored.
+func ParseGoMod(data []byte) (*GoMod, error) {
+	f := &GoMod{Replace: map[Module]Module{}}
+	var block string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i > -1 {
+			line = line[:i]
+		}
+		fields, err := tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("go.mod:%d: %v", n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			if err := f.directive(block, fields); err != nil {
+				return nil, fmt.Errorf("go.mod:%d: %v", n, err)
+			}
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		if err := f.directive(fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("go.mod:%d: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if f.Module == "" {
+		return nil, fmt.Errorf("go.mod: no module directive")
+	}
+	return f, nil
+}
+
+func (f *GoMod) directive(verb string, args []string) error {
+	switch verb {
+	case "module":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: module path")
+		}
+		f.Module = args[0]
+	case "require":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: require path version")
+		}
+		f.Require = append(f.Require, Module{Path: args[0], Version: args[1]})
+	case "replace":
+		// replace old [version] => new [version]
+		arrow := -1
+		for i, a := range args {
+			if a == "=>" {
+				arrow = i
+			}
+		}
+		if arrow < 1 || arrow > 2 || len(args)-arrow-1 < 1 || len(args)-arrow-1 > 2 {
+			return fmt.Errorf("usage: replace old [version] => new [version]")
+		}
+		var old, new Module
+		old.Path = args[0]
+		if arrow == 2 {
+			old.Version = args[1]
+		}
+		new.Path = args[arrow+1]
+		if len(args) == arrow+3 {
+			new.Version = args[arrow+2]
+		} else if !isDir(new.Path) {
+			return fmt.Errorf("replacement module %s without version must be a directory path", new.Path)
+		}
+		f.Replace[old] = new
+	}
+	return nil
+}
+
+// replacement finds the replacement for a module version, if any.
+func (f *GoMod) replacement(m Module) (Module, bool) {
+	if r, ok := f.Replace[m]; ok {
+		return r, true
+	}
+	r, ok := f.Replace[Module{Path: m.Path}]
+	return r, ok
+}
+
+func isDir(path string) bool {
+	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") || strings.HasPrefix(path, "/")
+}
+
+// tokenize splits a line into fields, unquoting quoted strings.
+func tokenize(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] == '"' || line[0] == '`' {
+			end := strings.IndexByte(line[1:], line[0])
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			s, err := strconv.Unquote(line[:end+2])
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, s)
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
+
+// ParseGoSum parses a go.sum file into a map of "path version" (or "path version/go.mod") to hash.
+func ParseGoSum(data []byte) map[string]string {
+	sums := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		sums[fields[0]+" "+fields[1]] = fields[2]
+	}
+	return sums
+}
+
+// compareVersions compares two semantic versions (e.g. v1.2.3, v1.2.3-pre, v0.0.0-20180101000000-abcdef123456
+// or v2.0.0+incompatible) and returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	pa, pb := parseVersion(a), parseVersion(b)
+	for i := 0; i < 3; i++ {
+		if c := compareNumbers(pa.nums[i], pb.nums[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case pa.pre == pb.pre:
+		return 0
+	case pa.pre == "":
+		return 1
+	case pb.pre == "":
+		return -1
+	}
+	ia, ib := strings.Split(pa.pre, "."), strings.Split(pb.pre, ".")
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		if ia[i] == ib[i] {
+			continue
+		}
+		na, nb := isNumber(ia[i]), isNumber(ib[i])
+		switch {
+		case na && nb:
+			return compareNumbers(ia[i], ib[i])
+		case na:
+			return -1
+		case nb:
+			return 1
+		case ia[i] < ib[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareNumbers(strconv.Itoa(len(ia)), strconv.Itoa(len(ib)))
+}
+
+type version struct {
+	nums [3]string
+	pre  string
+}
+
+func parseVersion(v string) version {
+	var p version
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i > -1 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i > -1 {
+		v, p.pre = v[:i], v[i+1:]
+	}
+	for i, n := range strings.SplitN(v, ".", 3) {
+		p.nums[i] = n
+	}
+	for i := range p.nums {
+		if p.nums[i] == "" {
+			p.nums[i] = "0"
+		}
+	}
+	return p
+}
+
+// compareNumbers compares two decimal strings of any length.
+func compareNumbers(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGoMod(t *testing.T) {
+	f, err := ParseGoMod([]byte(`module example.com/a // comment
+
+go 1.12
+
+require (
+	example.com/b v1.2.3
+	"example.com/c" v0.0.0-20180101000000-abcdef123456 // indirect
+)
+
+require example.com/d v2.0.0+incompatible
+
+exclude example.com/b v1.0.0
+
+replace example.com/b => ./b
+replace (
+	example.com/c v1.0.0 => example.com/e v1.1.0
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &GoMod{
+		Module: "example.com/a",
+		Require: []Module{
+			{"example.com/b", "v1.2.3"},
+			{"example.com/c", "v0.0.0-20180101000000-abcdef123456"},
+			{"example.com/d", "v2.0.0+incompatible"},
+		},
+		Replace: map[Module]Module{
+			{Path: "example.com/b"}:     {Path: "./b"},
+			{"example.com/c", "v1.0.0"}: {"example.com/e", "v1.1.0"},
+		},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Fatalf("unexpected go.mod %#v", f)
+	}
+	if r, ok := f.replacement(Module{"example.com/b", "v1.2.3"}); !ok || r.Path != "./b" {
+		t.Fatalf("unexpected replacement %v", r)
+	}
+	if _, ok := f.replacement(Module{"example.com/c", "v1.0.1"}); ok {
+		t.Fatal("unexpected replacement")
+	}
+
+	for _, invalid := range []string{
+		"require example.com/b",
+		"module a\nrequire (\n\t\"example.com/b v1.0.0\n)",
+		"module a\nreplace example.com/b => example.com/c",
+		"go 1.12",
+	} {
+		if _, err := ParseGoMod([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestParseGoSum(t *testing.T) {
+	sums := ParseGoSum([]byte("example.com/b v1.2.3 h1:abc=\nexample.com/b v1.2.3/go.mod h1:def=\n\n"))
+	if len(sums) != 2 || sums["example.com/b v1.2.3"] != "h1:abc=" || sums["example.com/b v1.2.3/go.mod"] != "h1:def=" {
+		t.Fatalf("unexpected sums %v", sums)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	// In increasing order.
+	versions := []string{
+		"v0.0.0-20180101000000-abcdef123456",
+		"v0.0.0-20190101000000-abcdef123456",
+		"v0.1.0",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0+incompatible",
+	}
+	for i, a := range versions {
+		for j, b := range versions {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if c := compareVersions(a, b); c != expected {
+				t.Errorf("compareVersions(%s, %s) = %d, expected %d", a, b, c, expected)
+			}
+		}
+	}
+	if compareVersions("v1.0.0+meta", "v1.0.0") != 0 {
+		t.Error("build metadata should be ignored")
+	}
+}
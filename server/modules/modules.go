@@ -0,0 +1,381 @@
+// Package modules places the module versions required by a go.mod file in the GOPATH of a session, so
+// the GOPATH based getter and deployer build against them instead of the default branch.
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+	"github.com/dave/services/fsutil"
+	"github.com/dave/services/getter/gettermsg"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type Module struct {
+	Path    string
+	Version string
+}
+
+func (m Module) String() string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + "@" + m.Version
+}
+
+// Find looks for a go.mod file in the GOPATH directory of the package and its parents. If it's found,
+// dir is the directory containing it. The go.sum file is optional.
+func Find(fs billy.Filesystem, path string) (dir string, mod, sum []byte, err error) {
+	src := filepath.Join("gopath", "src")
+	for dir = filepath.Join(src, path); dir != src && dir != "."; dir = filepath.Dir(dir) {
+		mod, err = readFile(fs, filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if mod == nil {
+			continue
+		}
+		sum, err = readFile(fs, filepath.Join(dir, "go.sum"))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return dir, mod, sum, nil
+	}
+	return "", nil, nil, nil
+}
+
+// Resolve selects the module versions required by the go.mod file (using minimal version selection,
+// like the go command), downloads them from config.ModuleProxy, verifies them against go.sum and
+// writes them to GOPATH. dir is the directory of the main module in fs, or empty if the main module
+// isn't in fs (directory replacements are resolved relative to it). Modules missing from go.sum are
+// rejected. It returns the selected version of each module.
+func Resolve(ctx context.Context, fs billy.Filesystem, send func(services.Message), dir string, mod, sum []byte) (map[string]string, error) {
+	main, err := ParseGoMod(mod)
+	if err != nil {
+		return nil, err
+	}
+	r := &resolver{
+		fs:   fs,
+		send: send,
+		main: main,
+		dir:  dir,
+		sums: ParseGoSum(sum),
+	}
+
+	selected, err := r.selectVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the module path doesn't match the directory (e.g. a vanity import path), packages in the main
+	// module import each other using the module path, so it must be in GOPATH at the module path too.
+	src := filepath.Join("gopath", "src")
+	if dir != "" && dir != filepath.Join(src, main.Module) {
+		if err := fsutil.Copy(fs, filepath.Join(src, main.Module), fs, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.place(ctx, selected); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+type resolver struct {
+	fs   billy.Filesystem
+	send func(services.Message)
+	main *GoMod
+	dir  string
+	sums map[string]string
+}
+
+// selectVersions walks the requirement graph and selects the maximum version of each module.
+func (r *resolver) selectVersions(ctx context.Context) (map[string]string, error) {
+	selected := map[string]string{}
+	visited := map[Module]bool{}
+	queue := append([]Module(nil), r.main.Require...)
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		if m.Path == r.main.Module || visited[m] {
+			continue
+		}
+		if !clean(m.Path) {
+			return nil, fmt.Errorf("invalid module path %q", m.Path)
+		}
+		visited[m] = true
+		if v, ok := selected[m.Path]; !ok || compareVersions(m.Version, v) > 0 {
+			selected[m.Path] = m.Version
+		}
+		reqs, err := r.requirements(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, reqs...)
+	}
+	return selected, nil
+}
+
+// requirements returns the requirements in the go.mod of a module version (or its replacement).
+func (r *resolver) requirements(ctx context.Context, m Module) ([]Module, error) {
+	target, replaced := r.main.replacement(m)
+	if !replaced {
+		target = m
+	}
+	var data []byte
+	if target.Version == "" {
+		if r.dir == "" {
+			return nil, fmt.Errorf("can't replace %s with directory %s", m, target.Path)
+		}
+		var err error
+		if data, err = readFile(r.fs, filepath.Join(r.dir, target.Path, "go.mod")); err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, nil
+		}
+	} else {
+		var err error
+		if data, err = download(ctx, proxyUrl(target, "mod"), config.ModuleMaxSize); err != nil {
+			return nil, err
+		}
+		if err := r.verify(target.Path+" "+target.Version+"/go.mod", map[string][]byte{"go.mod": data}); err != nil {
+			return nil, err
+		}
+	}
+	f, err := ParseGoMod(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", target, err)
+	}
+	return f.Require, nil
+}
+
+// place downloads the selected modules (in parallel) and writes them to GOPATH.
+func (r *resolver) place(ctx context.Context, selected map[string]string) error {
+
+	var paths []string
+	for path := range selected {
+		// Requirements that contain the main module can't be placed in GOPATH without overwriting it, so
+		// packages from these are built from the main module's repo.
+		if strings.HasPrefix(r.main.Module, path+"/") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	type result struct {
+		files map[string][]byte
+		err   error
+	}
+	results := make([]result, len(paths))
+	sem := make(chan struct{}, config.ConcurrentModuleDownloads)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		m := Module{Path: path, Version: selected[path]}
+		target, replaced := r.main.replacement(m)
+		if !replaced {
+			target = m
+		}
+		if target.Version == "" {
+			// Directory replacements are copied from the main module.
+			continue
+		}
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r.send(gettermsg.Downloading{Message: target.String()})
+			files, err := r.fetch(ctx, target)
+			results[i] = result{files, err}
+		}()
+	}
+	wg.Wait()
+
+	src := filepath.Join("gopath", "src")
+	for i, path := range paths {
+		dest := filepath.Join(src, path)
+		// Nested modules in the main module's repo must be replaced by the selected version.
+		if err := util.RemoveAll(r.fs, dest); err != nil {
+			return err
+		}
+		m := Module{Path: path, Version: selected[path]}
+		target, replaced := r.main.replacement(m)
+		if replaced && target.Version == "" {
+			if err := fsutil.Copy(r.fs, dest, r.fs, filepath.Join(r.dir, target.Path)); err != nil {
+				return err
+			}
+			continue
+		}
+		if results[i].err != nil {
+			return results[i].err
+		}
+		for name, contents := range results[i].files {
+			if err := fsutil.WriteFile(r.fs, filepath.Join(dest, name), 0666, contents); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetch downloads and verifies a module zip, and returns the files relative to the module root.
+func (r *resolver) fetch(ctx context.Context, m Module) (map[string][]byte, error) {
+	data, err := download(ctx, proxyUrl(m, "zip"), config.ModuleMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	all, files, err := unzip(m, data, config.ModuleMaxUnzippedSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.verify(m.Path+" "+m.Version, all); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// unzip reads a module zip. It returns the files by their names in the zip (for the hash), and by their
+// names relative to the module root. Names that would be written outside the module are rejected.
+func unzip(m Module, data []byte, max int64) (all, files map[string][]byte, err error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", m, err)
+	}
+	prefix := m.Path + "@" + m.Version + "/"
+	all = map[string][]byte{}
+	files = map[string][]byte{}
+	var total int64
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if !strings.HasPrefix(f.Name, prefix) || !clean(strings.TrimPrefix(f.Name, prefix)) {
+			return nil, nil, fmt.Errorf("%s: unexpected file %s in zip", m, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		// The sizes in the zip headers aren't trusted, so we read one byte more than the remaining
+		// allowance to detect a zip that's too large.
+		b, err := ioutil.ReadAll(io.LimitReader(rc, max-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if total += int64(len(b)); total > max {
+			return nil, nil, fmt.Errorf("%s: unzipped files larger than %d bytes", m, max)
+		}
+		all[f.Name] = b
+		files[strings.TrimPrefix(f.Name, prefix)] = b
+	}
+	return all, files, nil
+}
+
+// clean returns true if the slash separated path is relative and has no "." or ".." elements, so it
+// can be joined to a directory without escaping it.
+func clean(name string) bool {
+	return name != "" && !path.IsAbs(name) && !strings.Contains(name, "\\") && path.Clean(name) == name && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// verify checks the files against the go.sum hash. Modules missing from go.sum are rejected, because
+// the proxy could serve anything for them.
+func (r *resolver) verify(key string, files map[string][]byte) error {
+	want, ok := r.sums[key]
+	if !ok {
+		return fmt.Errorf("missing go.sum entry for %s (run go mod tidy)", key)
+	}
+	if got := hash1(files); got != want {
+		return fmt.Errorf("checksum mismatch for %s: go.sum has %s, downloaded %s", key, want, got)
+	}
+	return nil
+}
+
+// hash1 is the "h1:" hash used in go.sum: the SHA-256 of a summary listing the SHA-256 of each file.
+func hash1(files map[string][]byte) string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(files[name]), name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func proxyUrl(m Module, ext string) string {
+	return fmt.Sprintf("%s/%s/@v/%s.%s", strings.TrimSuffix(config.ModuleProxy, "/"), escape(m.Path), escape(m.Version), ext)
+}
+
+// escape encodes upper case letters as ! followed by the lower case letter, as required by the module
+// proxy protocol.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func download(ctx context.Context, url string, max int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("error downloading %s: larger than %d bytes", url, max)
+	}
+	return b, nil
+}
+
+// readFile returns nil if the file doesn't exist.
+func readFile(fs billy.Filesystem, fpath string) ([]byte, error) {
+	f, err := fs.Open(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
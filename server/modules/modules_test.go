@@ -0,0 +1,113 @@
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services/fsutil"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func TestSelectVersions(t *testing.T) {
+	// The modules are replaced by directories, so nothing is downloaded.
+	fs := memfs.New()
+	for name, contents := range map[string]string{
+		"main/a/go.mod": "module example.com/a\nrequire example.com/c v1.2.0\n",
+		"main/b/go.mod": "module example.com/b\nrequire (\n\texample.com/c v1.3.0\n\texample.com/main v1.0.0\n)\n",
+		"main/c/go.mod": "module example.com/c\nrequire example.com/a v1.1.0\n",
+	} {
+		if err := fsutil.WriteFile(fs, name, 0666, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main, err := ParseGoMod([]byte(`module example.com/main
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+replace (
+	example.com/a => ./a
+	example.com/b => ./b
+	example.com/c => ./c
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &resolver{fs: fs, main: main, dir: "main"}
+	selected, err := r.selectVersions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The maximum required version of each module is selected, and the main module is skipped.
+	if len(selected) != 3 || selected["example.com/a"] != "v1.1.0" || selected["example.com/b"] != "v1.0.0" || selected["example.com/c"] != "v1.3.0" {
+		t.Fatalf("unexpected versions %v", selected)
+	}
+
+	r.main.Require = append(r.main.Require, Module{"../escape", "v1.0.0"})
+	if _, err := r.selectVersions(context.Background()); err == nil {
+		t.Fatal("expected an error for an invalid module path")
+	}
+}
+
+func TestUnzip(t *testing.T) {
+	m := Module{"example.com/a", "v1.0.0"}
+	zipped := func(names ...string) []byte {
+		buf := &bytes.Buffer{}
+		w := zip.NewWriter(buf)
+		for _, name := range names {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte(name))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	all, files, err := unzip(m, zipped("example.com/a@v1.0.0/go.mod", "example.com/a@v1.0.0/b/b.go"), config.ModuleMaxUnzippedSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || string(files["b/b.go"]) != "example.com/a@v1.0.0/b/b.go" {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	r := &resolver{sums: map[string]string{"example.com/a v1.0.0": hash1(all)}}
+	if err := r.verify("example.com/a v1.0.0", all); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verify("example.com/a v1.0.0", map[string][]byte{"go.mod": nil}); err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+	if err := r.verify("example.com/b v1.0.0", all); err == nil {
+		t.Fatal("expected an error for a module missing from go.sum")
+	}
+
+	for _, name := range []string{
+		"example.com/b@v1.0.0/go.mod",
+		"example.com/a@v1.0.0/../../escape.go",
+		"example.com/a@v1.0.0//etc/passwd",
+		"example.com/a@v1.0.0/./a.go",
+		"example.com/a@v1.0.0/a\\..\\..\\escape.go",
+	} {
+		if _, _, err := unzip(m, zipped(name), config.ModuleMaxUnzippedSize); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+
+	// The limit is on the total size, and a zip over it is rejected rather than truncated.
+	data := zipped("example.com/a@v1.0.0/a.go", "example.com/a@v1.0.0/b.go")
+	if _, _, err := unzip(m, data, int64(len("example.com/a@v1.0.0/a.go")*2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := unzip(m, data, int64(len("example.com/a@v1.0.0/a.go")*2-1)); err == nil {
+		t.Fatal("expected an error for a zip over the limit")
+	}
+}
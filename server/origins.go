@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Origins is the websocket origin policy for each route.
+type Origins map[string]*OriginPolicy
+
+// OriginPolicy is the set of origins that may open a websocket on a route.
+type OriginPolicy struct {
+	Any     bool            // Allow any origin
+	Origins map[string]bool // Allowed origins in the form scheme://host
+}
+
+// NewOrigins builds the origin policies. The pages for each route (keys of the host and protocol maps)
+// are allowed, along with the operator-configured embedders.
+func NewOrigins(host, protocol map[string]string, pages, embedders map[string][]string) Origins {
+	o := Origins{}
+	policy := func(route string) *OriginPolicy {
+		if o[route] == nil {
+			o[route] = &OriginPolicy{Origins: map[string]bool{}}
+		}
+		return o[route]
+	}
+	for route, keys := range pages {
+		p := policy(route)
+		for _, key := range keys {
+			p.Origins[strings.ToLower(protocol[key]+"://"+host[key])] = true
+		}
+	}
+	for route, origins := range embedders {
+		p := policy(route)
+		for _, origin := range origins {
+			if origin == "*" {
+				p.Any = true
+				continue
+			}
+			p.Origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	return o
+}
+
+// Allowed checks the Origin header of a request to the route. Requests without an Origin header are not
+// from browsers (e.g. the wasmgo command), so they can't be used by a third party site and are allowed.
+// Requests from the same host as the server are always allowed.
+func (o Origins) Allowed(route string, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	p, ok := o[route]
+	if !ok {
+		return false
+	}
+	return p.Any || p.Origins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
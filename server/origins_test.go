@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/jobs"
+	"github.com/dave/jsgo/server/limiter"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/scheduler"
+	"github.com/dave/jsgo/server/servermsg"
+	"github.com/dave/services"
+	"github.com/dave/services/tracker"
+	"github.com/gorilla/websocket"
+)
+
+type testInstruction struct {
+	Path string
+}
+
+type testDone struct{}
+
+// testSocketHandler is a SocketHandlerInterface that finishes as soon as it receives an instruction.
+type testSocketHandler struct {
+	lane string
+}
+
+func (t *testSocketHandler) Handle(ctx context.Context, req *http.Request, send func(message services.Message), receive chan services.Message, tj *tracker.Job) error {
+	select {
+	case <-receive:
+		send(testDone{})
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (t *testSocketHandler) Lane() string                        { return t.lane }
+func (t *testSocketHandler) RequestTimeout() time.Duration       { return time.Second * 5 }
+func (t *testSocketHandler) WebsocketPingPeriod() time.Duration  { return time.Second * 5 }
+func (t *testSocketHandler) WebsocketTimeout() time.Duration     { return time.Second * 5 }
+func (t *testSocketHandler) WebsocketPongTimeout() time.Duration { return time.Second * 5 }
+
+func (t *testSocketHandler) Protocols() []string { return []string{"json", "json.v1"} }
+
+func (t *testSocketHandler) VersionNotSupported() services.Message {
+	return servermsg.Error{Code: servermsg.CodeVersionNotSupported}
+}
+
+func (t *testSocketHandler) MarshalMessage(protocol string, m services.Message) ([]byte, int, error) {
+	b, err := json.Marshal(m)
+	return b, websocket.TextMessage, err
+}
+
+func (t *testSocketHandler) UnarshalMessage(protocol string, b []byte) (services.Message, error) {
+	var m testInstruction
+	err := json.Unmarshal(b, &m)
+	return m, err
+}
+
+func (t *testSocketHandler) StoreError(ctx context.Context, err error, req *http.Request) {}
+
+func newTestHandler() *Handler {
+	host := map[string]string{
+		config.Jsgo: "compile.example.com",
+		config.Play: "play.example.com",
+	}
+	protocol := map[string]string{
+		config.Jsgo: "https",
+		config.Play: "https",
+	}
+	pages := map[string][]string{
+		config.Jsgo: {config.Jsgo},
+		config.Play: {config.Play},
+	}
+	embedders := map[string][]string{
+		config.Jsgo:  {"https://embed.example.org/"},
+		config.Frizz: {"*"},
+	}
+	h := &Handler{
+		mux:          http.NewServeMux(),
+		shutdown:     make(chan struct{}),
+		Queue:        scheduler.New(1, 10, 0, map[string]int{config.Jsgo: 1, config.Play: 1, config.Frizz: 1, config.Wasm: 1}, time.Second),
+		Jobs:         jobs.New(),
+		SocketLimits: limiter.New(nil),
+		PageLimits:   limiter.New(nil),
+		Origins:      NewOrigins(host, protocol, pages, embedders),
+		Waitgroup:    &sync.WaitGroup{},
+		Log:          logger.New(ioutil.Discard, logger.Error),
+	}
+	for _, lane := range []string{config.Jsgo, config.Play, config.Frizz, config.Wasm} {
+		h.mux.HandleFunc("/_"+lane+"/", h.SocketHandler(&testSocketHandler{lane: lane}))
+	}
+	return h
+}
+
+func TestSocketHandlerOrigins(t *testing.T) {
+
+	s := httptest.NewServer(newTestHandler())
+	defer s.Close()
+
+	type spec struct {
+		name    string
+		route   string
+		origin  string
+		allowed bool
+	}
+
+	tests := []spec{
+		{"no origin", config.Jsgo, "", true},
+		{"same host", config.Jsgo, s.URL, true},
+		{"page", config.Jsgo, "https://compile.example.com", true},
+		{"page case", config.Jsgo, "https://Compile.Example.com", true},
+		{"page wrong scheme", config.Jsgo, "http://compile.example.com", false},
+		{"page wrong port", config.Jsgo, "https://compile.example.com:8443", false},
+		{"page of other route", config.Jsgo, "https://play.example.com", false},
+		{"page suffix", config.Jsgo, "https://compile.example.com.evil.com", false},
+		{"page prefix", config.Jsgo, "https://evilcompile.example.com", false},
+		{"forged", config.Jsgo, "https://evil.com", false},
+		{"null", config.Jsgo, "null", false},
+		{"garbage", config.Jsgo, "%%%", false},
+		{"embedder", config.Jsgo, "https://embed.example.org", true},
+		{"embedder on other route", config.Play, "https://embed.example.org", false},
+		{"play page", config.Play, "https://play.example.com", true},
+		{"wildcard", config.Frizz, "https://anything.example.net", true},
+		{"no policy", config.Wasm, "https://evil.com", false},
+		{"no policy no origin", config.Wasm, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.origin != "" {
+				header.Set("Origin", test.origin)
+			}
+			url := "ws" + strings.TrimPrefix(s.URL, "http") + "/_" + test.route + "/"
+			// Version 1 of the messages starts with the job ID.
+			dialer := websocket.Dialer{Subprotocols: []string{"json.v1"}}
+			conn, resp, err := dialer.Dial(url, header)
+			if !test.allowed {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("expected origin %q to be rejected on %s", test.origin, test.route)
+				}
+				if resp == nil || resp.StatusCode != http.StatusForbidden {
+					t.Fatalf("expected status 403, got %v (%v)", resp, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected origin %q to be allowed on %s: %v", test.origin, test.route, err)
+			}
+			defer conn.Close()
+
+			// The job should run to completion: the first message is the job ID, and the handler should
+			// send testDone after receiving the instruction.
+			if err := conn.WriteJSON(testInstruction{Path: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			var messages []string
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			for {
+				_, b, err := conn.ReadMessage()
+				if err != nil {
+					break
+				}
+				messages = append(messages, string(b))
+			}
+			if len(messages) == 0 || !strings.Contains(messages[0], `"ID"`) {
+				t.Fatalf("expected job message first, got %v", messages)
+			}
+			if messages[len(messages)-1] != "{}" {
+				t.Fatalf("expected handler to finish, got %v", messages)
+			}
+		})
+	}
+}
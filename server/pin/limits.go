@@ -0,0 +1,112 @@
+package pin
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+)
+
+// sizeLimit is the number of bytes written to the filesystems of a clone.
+type sizeLimit struct {
+	written int64
+	max     int64
+}
+
+// limitedFilesystem fails writes when the limit is reached.
+type limitedFilesystem struct {
+	billy.Filesystem
+	limit *sizeLimit
+}
+
+func (fs *limitedFilesystem) Create(filename string) (billy.File, error) {
+	return fs.wrap(fs.Filesystem.Create(filename))
+}
+
+func (fs *limitedFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return fs.wrap(fs.Filesystem.OpenFile(filename, flag, perm))
+}
+
+func (fs *limitedFilesystem) TempFile(dir, prefix string) (billy.File, error) {
+	return fs.wrap(fs.Filesystem.TempFile(dir, prefix))
+}
+
+func (fs *limitedFilesystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(fs, path), nil
+}
+
+func (fs *limitedFilesystem) wrap(f billy.File, err error) (billy.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &limitedFile{File: f, limit: fs.limit}, nil
+}
+
+type limitedFile struct {
+	billy.File
+	limit *sizeLimit
+}
+
+func (f *limitedFile) Write(p []byte) (int, error) {
+	if atomic.AddInt64(&f.limit.written, int64(len(p))) > f.limit.max {
+		return 0, fmt.Errorf("repo too large (max %d bytes)", f.limit.max)
+	}
+	return f.File.Write(p)
+}
+
+var progressRegex = []*regexp.Regexp{
+	regexp.MustCompile(`Counting objects: +(\d+)`),
+	regexp.MustCompile(`Enumerating objects: +(\d+)`),
+	regexp.MustCompile(`Finding sources: +\d+% \(\d+/(\d+)\)`),
+	regexp.MustCompile(`Receiving objects: +\d+% \(\d+/(\d+)\)`),
+}
+
+// progressWatcher reads the progress messages from the remote, and cancels the clone if the number of
+// objects is more than max (like gitfetcher).
+type progressWatcher struct {
+	max    int
+	cancel func()
+
+	m    sync.Mutex
+	line []byte
+	err  error
+}
+
+func (p *progressWatcher) Write(b []byte) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			p.line = append(p.line, c)
+			continue
+		}
+		p.check(string(p.line))
+		p.line = p.line[:0]
+	}
+	return len(b), nil
+}
+
+func (p *progressWatcher) check(line string) {
+	for _, r := range progressRegex {
+		matches := r.FindStringSubmatch(line)
+		if len(matches) != 2 {
+			continue
+		}
+		objects, err := strconv.Atoi(matches[1])
+		if err == nil && objects > p.max && p.err == nil {
+			p.err = fmt.Errorf("too many git objects (max %d): %d", p.max, objects)
+			p.cancel()
+		}
+	}
+}
+
+func (p *progressWatcher) error() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.err
+}
@@ -0,0 +1,205 @@
+// Package pin checks out a specific git ref (branch, tag or commit) of the repo being compiled, instead
+// of the default branch.
+package pin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/trace"
+	"github.com/dave/services"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// New wraps a fetcher. Repos are fetched by the wrapped fetcher unless the context was created with
+// WithRef and the url is the repo of the pinned package.
+func New(fetcher services.Fetcher) *Fetcher {
+	return &Fetcher{fetcher: fetcher}
+}
+
+type Fetcher struct {
+	fetcher services.Fetcher
+}
+
+// Pin is a request to build a package at a specific ref.
+type Pin struct {
+	Path string // Package path
+	Ref  string // Branch, tag or commit hash
+
+	m      sync.Mutex
+	commit string
+}
+
+// Commit returns the hash of the commit that was checked out, or an empty string if the pinned repo
+// wasn't fetched.
+func (p *Pin) Commit() string {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.commit
+}
+
+type pinKey struct{}
+
+// WithRef returns a context that pins the repo of path to ref.
+func WithRef(ctx context.Context, path, ref string) (context.Context, *Pin) {
+	p := &Pin{Path: path, Ref: ref}
+	return context.WithValue(ctx, pinKey{}, p), p
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, url string) (fs billy.Filesystem, err error) {
+	err = trace.Run(ctx, "fetch", func(ctx context.Context) error {
+		p, ok := ctx.Value(pinKey{}).(*Pin)
+		if !ok || !p.matches(url) {
+			fs, err = f.fetcher.Fetch(ctx, url)
+			return err
+		}
+		fs, err = p.fetch(ctx, url)
+		return err
+	}, "repo", url)
+	return fs, err
+}
+
+// matches returns true if url is the repo of the pinned package. Only packages hosted at their import
+// path are supported (e.g. github.com/foo/bar/baz matches https://github.com/foo/bar).
+func (p *Pin) matches(url string) bool {
+	if i := strings.Index(url, "://"); i > -1 {
+		url = url[i+3:]
+	}
+	url = strings.TrimSuffix(url, ".git")
+	return p.Path == url || strings.HasPrefix(p.Path, url+"/")
+}
+
+// fetch clones the ref and checks it out. Branches, tags and the commits they point to are cloned with
+// depth 1. Other commits need the history of the default branch. The clone is limited to
+// config.GitFetcherConfig.GitMaxObjects objects and config.PinMaxSize bytes, because it's kept in
+// memory. The repo isn't persisted, so it won't pollute the cache used for the default branch.
+func (p *Pin) fetch(ctx context.Context, url string) (billy.Filesystem, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.GitFetcherConfig.GitCloneTimeout)
+	defer cancel()
+
+	options, err := p.cloneOptions(url)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := &sizeLimit{max: config.PinMaxSize}
+	worktree := &limitedFilesystem{Filesystem: memfs.New(), limit: limit}
+	storage := filesystem.NewStorage(&limitedFilesystem{Filesystem: memfs.New(), limit: limit}, cache.NewObjectLRUDefault())
+
+	watcher := &progressWatcher{max: config.GitFetcherConfig.GitMaxObjects, cancel: cancel}
+	options.Progress = watcher
+	repo, err := git.CloneContext(ctx, storage, worktree, options)
+	if err != nil {
+		if limitErr := watcher.error(); limitErr != nil {
+			return nil, limitErr
+		}
+		return nil, err
+	}
+
+	hash, err := resolve(repo, p.Ref)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return nil, err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.commit = hash.String()
+
+	return worktree, nil
+}
+
+// cloneOptions lists the refs of the remote to find the branch or tag to clone.
+func (p *Pin) cloneOptions(url string) (*git.CloneOptions, error) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.CreateRemote(&gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	options := &git.CloneOptions{URL: url, SingleBranch: true, Tags: git.NoTags}
+	for _, name := range []plumbing.ReferenceName{plumbing.NewBranchReferenceName(p.Ref), plumbing.NewTagReferenceName(p.Ref)} {
+		for _, ref := range refs {
+			if ref.Name() == name {
+				options.ReferenceName, options.Depth = name, 1
+				return options, nil
+			}
+		}
+	}
+	if len(p.Ref) >= 4 && isHex(p.Ref) {
+		for _, ref := range refs {
+			if (ref.Name().IsBranch() || ref.Name().IsTag()) && strings.HasPrefix(ref.Hash().String(), strings.ToLower(p.Ref)) {
+				options.ReferenceName, options.Depth = ref.Name(), 1
+				return options, nil
+			}
+		}
+		// Any other commit needs the history of the default branch.
+		return options, nil
+	}
+	return nil, fmt.Errorf("can't find branch, tag or commit %s", p.Ref)
+}
+
+// resolve finds the commit for a tag, branch or (possibly abbreviated) commit hash.
+func resolve(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	for _, rev := range []string{ref, "origin/" + ref} {
+		if hash, err := repo.ResolveRevision(plumbing.Revision(rev)); err == nil {
+			return *hash, nil
+		}
+	}
+	if len(ref) >= 4 && len(ref) < 40 && isHex(ref) {
+		var found []plumbing.Hash
+		iter, err := repo.CommitObjects()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if err := iter.ForEach(func(c *object.Commit) error {
+			if strings.HasPrefix(c.Hash.String(), ref) {
+				found = append(found, c.Hash)
+			}
+			return nil
+		}); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		switch len(found) {
+		case 1:
+			return found[0], nil
+		case 0:
+		default:
+			return plumbing.ZeroHash, fmt.Errorf("commit %s is ambiguous", ref)
+		}
+	}
+	return plumbing.ZeroHash, fmt.Errorf("can't find branch, tag or commit %s", ref)
+}
+
+func isHex(s string) bool {
+	for _, r := range strings.ToLower(s) {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
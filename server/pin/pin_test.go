@@ -0,0 +1,97 @@
+package pin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=a", "-c", "user.email=a@b", "-c", "init.defaultBranch=master"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Skipf("git %v: %v %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(contents string) string {
+		if err := ioutil.WriteFile(dir+"/a.go", []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "a.go")
+		git("commit", "-m", contents)
+		return git("rev-parse", "HEAD")
+	}
+	git("init")
+	first := commit("first")
+	git("tag", "-a", "v1", "-m", "v1")
+	git("checkout", "-b", "feature")
+	feature := commit("feature")
+	git("checkout", "master")
+	last := commit("last")
+
+	for _, test := range []struct{ ref, commit, contents string }{
+		{"v1", first, "first"},
+		{"feature", feature, "feature"},
+		{"master", last, "last"},
+		{last, last, "last"},
+		{first[:7], first, "first"},
+	} {
+		p := &Pin{Path: "example.com/a", Ref: test.ref}
+		fs, err := p.fetch(context.Background(), "file://"+dir)
+		if err != nil {
+			t.Fatalf("%s: %v", test.ref, err)
+		}
+		f, err := fs.Open("a.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(b) != test.contents || p.Commit() != test.commit {
+			t.Fatalf("%s: unexpected %q at %s (%v)", test.ref, b, p.Commit(), err)
+		}
+	}
+
+	if _, err := (&Pin{Path: "example.com/a", Ref: "missing"}).fetch(context.Background(), "file://"+dir); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fs := &limitedFilesystem{Filesystem: memfs.New(), limit: &sizeLimit{max: 10}}
+	sub, err := fs.Chroot("objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(sub, "a", []byte("123456"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(fs, "b", []byte("123456"), 0666); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	var cancelled bool
+	p := &progressWatcher{max: 100, cancel: func() { cancelled = true }}
+	p.Write([]byte("Counting objects: 50, done.\r\nCounting obj"))
+	if p.error() != nil {
+		t.Fatal(p.error())
+	}
+	p.Write([]byte("ects: 150, done.\n"))
+	if p.error() == nil || !cancelled {
+		t.Fatal("expected an error")
+	}
+}
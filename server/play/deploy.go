@@ -11,8 +11,12 @@ import (
 	"github.com/dave/jsgo/assets"
 	"github.com/dave/jsgo/assets/std"
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/metrics"
+	"github.com/dave/jsgo/server/modules"
 	"github.com/dave/jsgo/server/play/messages"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/jsgo/server/store"
+	"github.com/dave/jsgo/server/trace"
 	"github.com/dave/services"
 	"github.com/dave/services/deployer"
 	"github.com/dave/services/getter/get"
@@ -23,13 +27,13 @@ import (
 func (h *Handler) Deploy(ctx context.Context, info messages.Deploy, req *http.Request, send func(message services.Message), receive chan services.Message) error {
 
 	if info.Source[info.Main] == nil {
-		return fmt.Errorf("can't find main package %s in source", info.Main)
+		return servermsg.Invalid(fmt.Errorf("can't find main package %s in source", info.Main))
 	}
 
 	s := session.New(info.Tags, assets.Assets, assets.Archives, h.Fileserver, config.ValidExtensions)
 
 	if err := s.SetSource(info.Source); err != nil {
-		return err
+		return servermsg.Invalid(err)
 	}
 
 	// Send a message to the client that downloading step has started.
@@ -39,45 +43,75 @@ func (h *Handler) Deploy(ctx context.Context, info messages.Deploy, req *http.Re
 	if info.Main == "main" {
 		// Using package path "main" as a hint isn't useful... Instead use the imports.
 		// TODO: ignore standard library packages in this list.
-		if err := gitreq.InitialiseFromHints(ctx, info.Imports...); err != nil {
-			return err
+		if err := trace.Run(ctx, "InitialiseFromHints", func(ctx context.Context) error {
+			return gitreq.InitialiseFromHints(ctx, info.Imports...)
+		}); err != nil {
+			return servermsg.Failed(servermsg.PhaseDownload, info.Main, err)
 		}
 	} else {
-		if err := gitreq.InitialiseFromHints(ctx, info.Main); err != nil {
-			return err
+		if err := trace.Run(ctx, "InitialiseFromHints", func(ctx context.Context) error {
+			return gitreq.InitialiseFromHints(ctx, info.Main)
+		}); err != nil {
+			return servermsg.Failed(servermsg.PhaseDownload, info.Main, err)
 		}
 	}
 
 	// set insecure = true in local mode or it will fail if git repo has git protocol
 	insecure := config.LOCAL
 
+	// If the source includes a go.mod file, place the required module versions in GOPATH so the getter
+	// doesn't download the default branch.
+	var versions map[string]string
+	if mod, ok := info.Source[info.Main]["go.mod"]; ok {
+		var err error
+		sum := info.Source[info.Main]["go.sum"]
+		if versions, err = modules.Resolve(ctx, s.GoPath(), send, "", []byte(mod), []byte(sum)); err != nil {
+			return servermsg.Failed(servermsg.PhaseDownload, info.Main, err)
+		}
+	}
+
 	// Start the download process - just like the "go get" command.
-	if err := get.New(s, send, gitreq).Get(ctx, info.Main, false, insecure, false); err != nil {
-		return err
+	if err := trace.Run(ctx, "get", func(ctx context.Context) error {
+		send, end := trace.Gets(ctx, send)
+		defer end()
+		return get.New(s, send, gitreq).Get(ctx, info.Main, false, insecure, false)
+	}, "path", info.Main); err != nil {
+		return servermsg.Failed(servermsg.PhaseDownload, info.Main, err)
 	}
 
 	if err := gitreq.Close(ctx); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, info.Main, err)
 	}
 
 	// Send a message to the client that downloading step has finished.
 	send(gettermsg.Downloading{Done: true})
 
 	// Start the compile process - this compiles to JS and sends the files to a GCS bucket.
-	output, err := deployer.New(s, send, std.Index, std.Prelude, config.DeployerConfig).Deploy(ctx, info.Main, deployer.HashIndex, map[bool]bool{true: true, false: false})
-	if err != nil {
+	var output map[bool]*deployer.DeployOutput
+	err := trace.Run(ctx, "deploy", func(ctx context.Context) error {
+		send, end := trace.Builds(ctx, send)
+		defer end()
+		var err error
+		output, err = deployer.New(s, send, std.Index, std.Prelude, config.DeployerConfig).Deploy(ctx, info.Main, deployer.HashIndex, map[bool]bool{true: true, false: false})
 		return err
+	}, "path", info.Main)
+	if err != nil {
+		return servermsg.Failed(servermsg.PhaseBuild, info.Main, err)
+	}
+	for _, o := range output {
+		metrics.StdArchives(o.Packages)
 	}
 
 	if err := h.storeDeploy(ctx, send, true, req, output[true]); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, info.Main, err)
 	}
 
 	// Send a message to the client that the process has successfully finished
 	// TODO: make minify configurable
 	send(messages.DeployComplete{
-		Main:  fmt.Sprintf("%x", output[true].MainHash),
-		Index: fmt.Sprintf("%x", output[true].IndexHash),
+		Main:    fmt.Sprintf("%x", output[true].MainHash),
+		Index:   fmt.Sprintf("%x", output[true].IndexHash),
+		Modules: versions,
 	})
 
 	return nil
@@ -11,6 +11,7 @@ import (
 	"github.com/dave/jsgo/assets"
 	"github.com/dave/jsgo/config"
 	"github.com/dave/jsgo/server/play/messages"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
 	"github.com/dave/services/getter/get"
 	"github.com/dave/services/getter/gettermsg"
@@ -24,7 +25,7 @@ func (h *Handler) Get(ctx context.Context, info messages.Get, req *http.Request,
 	g := get.New(s, send, h.Cache.NewRequest(false))
 	_, err := getSource(ctx, g, s, info.Path, send)
 	if err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseDownload, info.Path, err)
 	}
 	return nil
 }
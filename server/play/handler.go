@@ -6,23 +6,24 @@ import (
 	"fmt"
 	"net/http"
 
-	"cloud.google.com/go/datastore"
-
 	"time"
 
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/logger"
 	"github.com/dave/jsgo/server/play/messages"
+	"github.com/dave/jsgo/server/scheduler"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/jsgo/server/store"
 	"github.com/dave/services"
 	"github.com/dave/services/getter/cache"
-	"github.com/dave/services/queue"
 	"github.com/dave/services/tracker"
 )
 
 type Handler struct {
 	Cache      *cache.Cache
 	Fileserver services.Fileserver
-	Database   services.Database
+	Database   store.Database
+	Log        *logger.Logger
 }
 
 func (h *Handler) Handle(ctx context.Context, req *http.Request, send func(message services.Message), receive chan services.Message, tj *tracker.Job) error {
@@ -41,14 +42,18 @@ func (h *Handler) Handle(ctx context.Context, req *http.Request, send func(messa
 		case messages.Initialise:
 			return h.Initialise(ctx, m, req, send, receive)
 		default:
-			return fmt.Errorf("invalid init message %T", m)
+			return servermsg.Invalid(fmt.Errorf("invalid init message %T", m))
 		}
 	case <-time.After(config.WebsocketInstructionTimeout):
 		tj.Log("timeout")
-		return errors.New("timed out waiting for instruction from client")
+		return &servermsg.Failure{Code: servermsg.CodeTimeout, Err: errors.New("timed out waiting for instruction from client")}
 	}
 }
 
+func (h *Handler) Lane() string {
+	return config.Play
+}
+
 func (h *Handler) RequestTimeout() time.Duration {
 	return config.RequestTimeout
 }
@@ -65,24 +70,33 @@ func (h *Handler) WebsocketPongTimeout() time.Duration {
 	return config.WebsocketPongTimeout
 }
 
-func (h *Handler) MarshalMessage(m services.Message) (payload []byte, messageType int, err error) {
-	return messages.Marshal(m)
+func (h *Handler) Protocols() []string {
+	return messages.Codecs.Protocols()
+}
+
+func (h *Handler) VersionNotSupported() services.Message {
+	return servermsg.Error{Code: servermsg.CodeVersionNotSupported, Message: "client version not supported - please upgrade"}
+}
+
+func (h *Handler) MarshalMessage(protocol string, m services.Message) (payload []byte, messageType int, err error) {
+	return messages.Codecs.Marshal(protocol, m)
 }
 
-func (h *Handler) UnarshalMessage(b []byte) (services.Message, error) {
-	return messages.Unmarshal(b)
+func (h *Handler) UnarshalMessage(protocol string, b []byte) (services.Message, error) {
+	return messages.Codecs.Unmarshal(protocol, b)
 }
 
 func (h *Handler) StoreError(ctx context.Context, err error, req *http.Request) {
 
-	fmt.Println(err)
+	// The logger in the context has the job, route, path and IP fields.
+	logger.FromContext(ctx, h.Log).Error("request failed", "error", err, "phase", servermsg.NewError(err).Phase)
 
-	if err == queue.TooManyItemsQueued {
+	if scheduler.IsFlood(err) {
 		// If the server is getting flooded by a DOS, this will prevent database flooding
 		return
 	}
 
-	h.Database.Put(ctx, datastore.IncompleteKey(config.ErrorKind, nil), &store.Error{
+	store.StoreError(ctx, h.Database, store.Error{
 		Time:  time.Now(),
 		Error: err.Error(),
 		Ip:    req.Header.Get("X-Forwarded-For"),
@@ -1,23 +1,23 @@
 package messages
 
 import (
-	"encoding/json"
-	"fmt"
-	"reflect"
-
+	"github.com/dave/jsgo/server/codec"
 	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
 	"github.com/dave/services/builder/buildermsg"
 	"github.com/dave/services/constor/constormsg"
 	"github.com/dave/services/deployer/deployermsg"
 	"github.com/dave/services/getter/gettermsg"
-	"github.com/gorilla/websocket"
 )
 
-var payloads = []interface{}{
+// Codecs encodes the messages. JSON is the default.
+var Codecs = codec.NewRegistry(
+	codec.Json,
 
 	// Progress messages:
+	servermsg.Job{},
 	servermsg.Queueing{},
+	servermsg.Trace{},
 	gettermsg.Downloading{},
 
 	constormsg.Storing{},
@@ -25,6 +25,7 @@ var payloads = []interface{}{
 
 	// Data messages:
 	servermsg.Error{},
+	servermsg.Cancelled{},
 	ShareComplete{},
 	GetComplete{},
 	DeployComplete{},
@@ -33,16 +34,18 @@ var payloads = []interface{}{
 	deployermsg.ArchiveIndex{},
 
 	// Commands:
+	servermsg.Cancel{},
 	Update{},
 	Share{},
 	Get{},
 	Deploy{},
 	Initialise{},
-}
+)
 
 type DeployComplete struct {
-	Main  string
-	Index string
+	Main    string
+	Index   string
+	Modules map[string]string // Module path -> version used, when the source includes a go.mod file
 }
 
 // Update is sent by the client to the server asking it to compile the source and return the archive
@@ -87,44 +90,9 @@ type ShareComplete struct {
 }
 
 func Marshal(in services.Message) ([]byte, int, error) {
-	m := struct {
-		Type    string
-		Message services.Message
-	}{
-		Type:    reflect.TypeOf(in).Name(),
-		Message: in,
-	}
-	b, err := json.Marshal(m)
-	if err != nil {
-		return nil, 0, err
-	}
-	return b, websocket.TextMessage, nil
+	return Codecs.Marshal(codec.Json, in)
 }
 
 func Unmarshal(in []byte) (services.Message, error) {
-	var m struct {
-		Type    string
-		Message json.RawMessage
-	}
-	if err := json.Unmarshal(in, &m); err != nil {
-		return nil, err
-	}
-	typ, ok := payloadTypes[m.Type]
-	if !ok {
-		return nil, fmt.Errorf("type not found: %s, %#v", m.Type, m)
-	}
-	pointer := reflect.New(typ)
-	if err := json.Unmarshal(m.Message, pointer.Interface()); err != nil {
-		return nil, err
-	}
-	return pointer.Elem().Interface(), nil
+	return Codecs.Unmarshal(codec.Json, in)
 }
-
-func init() {
-	for _, i := range payloads {
-		t := reflect.TypeOf(i)
-		payloadTypes[t.Name()] = t
-	}
-}
-
-var payloadTypes = make(map[string]reflect.Type)
@@ -10,17 +10,17 @@ import (
 
 	"github.com/dave/jsgo/config"
 	"github.com/dave/jsgo/server/store"
-	"github.com/dave/services"
 )
 
-func Page(w http.ResponseWriter, req *http.Request, database services.Database) {
+func Page(w http.ResponseWriter, req *http.Request, database store.Database) {
 
 	ctx, cancel := context.WithTimeout(req.Context(), config.PageTimeout)
 	defer cancel()
 
 	var url string
 	if config.DEV {
-		url = "/_script.js"
+		// The page may be mounted at a prefix (see server.Router)
+		url = strings.TrimSuffix(req.Header.Get("X-Forwarded-Prefix"), "/") + "/_script.js"
 	} else {
 		found, c, err := store.Package(ctx, database, "github.com/dave/play")
 		if err != nil {
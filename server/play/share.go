@@ -14,6 +14,7 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/dave/jsgo/config"
 	"github.com/dave/jsgo/server/play/messages"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/jsgo/server/store"
 	"github.com/dave/play/models"
 	"github.com/dave/services"
@@ -35,13 +36,13 @@ func (h *Handler) Share(ctx context.Context, info messages.Share, req *http.Requ
 	sha := sha1.New()
 	w := io.MultiWriter(buf, sha)
 	if err := json.NewEncoder(w).Encode(sp); err != nil {
-		return err
+		return servermsg.Invalid(err)
 	}
 	hash := sha.Sum(nil)
 
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, "", err)
 	}
 	defer client.Close()
 
@@ -57,13 +58,13 @@ func (h *Handler) Share(ctx context.Context, info messages.Share, req *http.Requ
 		Send:      true,
 	})
 	if err := storer.Wait(); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, "", err)
 	}
 
 	send(constormsg.Storing{Done: true})
 
 	if err := h.storeShare(ctx, info.Source, fmt.Sprintf("%x", hash), send, req); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, "", err)
 	}
 
 	send(messages.ShareComplete{Hash: fmt.Sprintf("%x", hash)})
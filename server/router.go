@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dave/jsgo/config"
+)
+
+// pageTypes maps the sites (keys of config.Host) to page types
+var pageTypes = map[string]pageType{
+	config.Play:  PlayPage,
+	config.Jsgo:  JsgoPage,
+	config.Frizz: FrizzPage,
+	config.Wasm:  WasmPage,
+}
+
+// Router finds the page type of a request from its host and path.
+type Router struct {
+	mounts []mount
+}
+
+// mount is a page served at a host (or ":port" for any host on the port) and a path prefix.
+type mount struct {
+	host, prefix string
+	page         pageType
+}
+
+// NewRouter serves each page at the root of its host, and at the mounts in pages (see
+// config.Config.Pages). Mounts of unknown sites are ignored.
+func NewRouter(hosts, pages map[string]string) *Router {
+	r := &Router{}
+	for site, page := range pageTypes {
+		if host, ok := hosts[site]; ok {
+			r.add(host, page)
+		}
+	}
+	for m, site := range pages {
+		if page, ok := pageTypes[site]; ok {
+			r.add(m, page)
+		}
+	}
+	// The most specific mount wins: the longest prefix, then a host before a port.
+	sort.Slice(r.mounts, func(i, j int) bool {
+		a, b := r.mounts[i], r.mounts[j]
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+		if a.anyHost() != b.anyHost() {
+			return !a.anyHost()
+		}
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		return a.page < b.page
+	})
+	return r
+}
+
+func (r *Router) add(m string, page pageType) {
+	host, prefix := m, "/"
+	if i := strings.Index(m, "/"); i >= 0 {
+		host, prefix = m[:i], m[i:]
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	r.mounts = append(r.mounts, mount{host: strings.ToLower(host), prefix: prefix, page: page})
+}
+
+// Route returns the page type of the request and the prefix it's mounted at, or UnknownPage.
+func (r *Router) Route(req *http.Request) (pageType, string) {
+	host := strings.ToLower(req.Host)
+	for _, m := range r.mounts {
+		if m.anyHost() && !strings.HasSuffix(host, m.host) || !m.anyHost() && host != m.host {
+			continue
+		}
+		// "example.com/compile" is served by the mount at "example.com/compile/".
+		if strings.HasPrefix(req.URL.Path, m.prefix) || req.URL.Path+"/" == m.prefix {
+			return m.page, m.prefix
+		}
+	}
+	return UnknownPage, ""
+}
+
+func (m mount) anyHost() bool {
+	return strings.HasPrefix(m.host, ":")
+}
+
+// stripPrefix returns a copy of the request with the prefix removed from the path. The prefix is added
+// to X-Forwarded-Prefix, so the pages can link to themselves. This also supports reverse proxies that
+// strip the prefix and set X-Forwarded-Prefix themselves.
+func stripPrefix(req *http.Request, prefix string) *http.Request {
+	if prefix == "/" || prefix == "" {
+		return req
+	}
+	// A copy of the request, with its own URL and headers so the original isn't changed.
+	r := req.WithContext(req.Context())
+	u := *req.URL
+	r.URL = &u
+	r.Header = http.Header{}
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.URL.Path = strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(prefix, "/"))
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	r.URL.RawPath = ""
+	r.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(req.Header.Get("X-Forwarded-Prefix"), "/")+strings.TrimSuffix(prefix, "/"))
+	return r
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dave/jsgo/config"
+)
+
+func TestRouter(t *testing.T) {
+	hosts := map[string]string{
+		config.Jsgo:  "compile.jsgo.io",
+		config.Play:  "play.jsgo.io",
+		config.Frizz: "frizz.io",
+		config.Wasm:  "wasm.jsgo.io",
+		config.Src:   "src.jsgo.io",
+	}
+	pages := map[string]string{
+		"example.com/compile/": config.Jsgo,
+		"example.com/frizz":    config.Frizz,
+		"example.com":          config.Play,
+		"go.example.com":       config.Jsgo,
+		":8083":                config.Wasm,
+	}
+	r := NewRouter(hosts, pages)
+	for _, test := range []struct {
+		url, path, prefix string
+		page              pageType
+	}{
+		{"https://compile.jsgo.io/github.com/a/b", "/github.com/a/b", "/", JsgoPage},
+		{"https://PLAY.jsgo.io/", "/", "/", PlayPage},
+		{"https://wasm.jsgo.io/", "/", "/", WasmPage},
+		{"https://src.jsgo.io/", "/", "", UnknownPage},
+		{"https://go.example.com/github.com/a/b", "/github.com/a/b", "/", JsgoPage},
+		{"https://example.com/compile/github.com/a/b", "/github.com/a/b", "/compile/", JsgoPage},
+		{"https://example.com/compile", "/", "/compile/", JsgoPage},
+		{"https://example.com/frizz/_script.js", "/_script.js", "/frizz/", FrizzPage},
+		{"https://example.com/compiler", "/compiler", "/", PlayPage},
+		{"http://127.0.0.1:8083/", "/", "/", WasmPage},
+		{"http://127.0.0.1:18083/", "/", "", UnknownPage},
+	} {
+		req := httptest.NewRequest("GET", test.url, nil)
+		page, prefix := r.Route(req)
+		if page != test.page || prefix != test.prefix {
+			t.Errorf("%s: got page %d at %q, expected %d at %q", test.url, page, prefix, test.page, test.prefix)
+			continue
+		}
+		if path := stripPrefix(req, prefix).URL.Path; page != UnknownPage && path != test.path {
+			t.Errorf("%s: got path %q, expected %q", test.url, path, test.path)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/compile/github.com/a/b", nil)
+	req.Header.Set("X-Forwarded-Prefix", "/tools/")
+	if prefix := stripPrefix(req, "/compile/").Header.Get("X-Forwarded-Prefix"); prefix != "/tools/compile" {
+		t.Fatalf("unexpected prefix %q", prefix)
+	}
+}
+
+func TestPrefixedSocket(t *testing.T) {
+	h := newTestHandler()
+	h.mux = http.NewServeMux()
+	h.Router = NewRouter(map[string]string{config.Jsgo: "compile.jsgo.io"}, map[string]string{"example.com/compile/": config.Jsgo})
+	var prefix string
+	h.mux.HandleFunc("/_jsgo/", func(w http.ResponseWriter, req *http.Request) {
+		prefix = req.Header.Get("X-Forwarded-Prefix")
+	})
+	h.PageHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "https://example.com/compile/_jsgo/", nil))
+	if prefix != "/compile" {
+		t.Fatalf("expected the socket handler with prefix /compile, got %q", prefix)
+	}
+}
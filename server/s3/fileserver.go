@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// NewFileserver creates a fileserver that stores files in the object store.
+func NewFileserver(client *Client) *Fileserver {
+	return &Fileserver{client: client}
+}
+
+type Fileserver struct {
+	client *Client
+}
+
+func (f *Fileserver) Exists(ctx context.Context, bucket, name string) (bool, error) {
+	return f.client.Exists(ctx, bucket, name)
+}
+
+// Write stores a file. Immutable files (written with overwrite == false) aren't written if they exist,
+// and are cached indefinitely unless cacheControl is specified.
+func (f *Fileserver) Write(ctx context.Context, bucket, name string, reader io.Reader, overwrite bool, contentType, cacheControl string) (saved bool, err error) {
+	if !overwrite {
+		exists, err := f.client.Exists(ctx, bucket, name)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return false, nil
+		}
+	}
+	if cacheControl == "" {
+		if overwrite {
+			cacheControl = "no-cache"
+		} else {
+			cacheControl = "public,max-age=31536000,immutable"
+		}
+	}
+	// The signature includes the hash of the contents, so they're read before the request.
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false, err
+	}
+	if err := f.client.Put(ctx, bucket, name, contents, contentType, cacheControl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *Fileserver) Read(ctx context.Context, bucket, name string, writer io.Writer) (found bool, err error) {
+	return f.client.Get(ctx, bucket, name, writer)
+}
@@ -0,0 +1,251 @@
+// Package s3 is a client for S3-compatible object stores (e.g. MinIO), and a fileserver that stores
+// files in them. Requests are signed with AWS signature version 4 and use path-style URLs
+// (<endpoint>/<bucket>/<name>).
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dave/jsgo/config"
+)
+
+// Config configures the client.
+type Config struct {
+	Endpoint  string // e.g. "http://localhost:9000"
+	Region    string // Defaults to "us-east-1", which MinIO uses unless configured otherwise
+	AccessKey string
+	SecretKey string
+
+	// Buckets maps the bucket names used by the server (the values of config.Bucket) to the buckets in
+	// the object store. Names that aren't in the map are used unchanged.
+	Buckets map[string]string
+}
+
+// FromEnv creates a client from the S3_ENDPOINT, S3_REGION, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY
+// environment variables. S3_BUCKET_SRC, S3_BUCKET_PKG, S3_BUCKET_INDEX and S3_BUCKET_GIT map the buckets
+// in config.Bucket to the buckets in the object store. It returns nil if S3_ENDPOINT isn't set.
+func FromEnv() *Client {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	buckets := map[string]string{}
+	for _, site := range []string{config.Src, config.Pkg, config.Index, config.Git} {
+		if b := os.Getenv("S3_BUCKET_" + strings.ToUpper(site)); b != "" {
+			buckets[config.Bucket[site]] = b
+		}
+	}
+	return New(Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_REGION"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Buckets:   buckets,
+	})
+}
+
+// New creates a client.
+func New(c Config) *Client {
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	return &Client{config: c, now: time.Now}
+}
+
+type Client struct {
+	config Config
+	now    func() time.Time
+}
+
+// Put writes an object.
+func (c *Client) Put(ctx context.Context, bucket, name string, contents []byte, contentType, cacheControl string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+	resp, err := c.do(ctx, http.MethodPut, bucket, name, header, contents)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return check(resp)
+}
+
+// Get copies the contents of an object to w. It returns false if the object doesn't exist.
+func (c *Client) Get(ctx context.Context, bucket, name string, w io.Writer) (found bool, err error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, name, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := check(resp); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Exists returns true if the object exists.
+func (c *Client) Exists(ctx context.Context, bucket, name string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, name, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := check(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete deletes an object. Deleting an object that doesn't exist isn't an error.
+func (c *Client) Delete(ctx context.Context, bucket, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return check(resp)
+}
+
+func (c *Client) bucket(name string) string {
+	if b, ok := c.config.Buckets[name]; ok {
+		return b
+	}
+	return name
+}
+
+func (c *Client) do(ctx context.Context, method, bucket, name string, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + c.bucket(bucket) + "/" + name
+	u.Path = u.Path + path
+	u.RawPath = escape(u.Path)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, body)
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+// check returns an error with the S3 error message if the response isn't a success.
+func check(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, bytes.TrimSpace(b))
+}
+
+// sign adds the headers for AWS signature version 4. All the headers of the request are signed.
+func (c *Client) sign(req *http.Request, body []byte) {
+	t := c.now().UTC()
+	date := t.Format("20060102")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escape(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := date + "/" + c.config.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSha256([]byte("AWS4"+c.config.SecretKey), date)
+	key = hmacSha256(key, c.config.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.config.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for k, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Replace(strings.Join(pairs, "&"), "/", "%2F", -1)
+}
+
+// escape URI encodes s as required by the signature: everything except the unreserved characters and
+// "/" is percent encoded.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
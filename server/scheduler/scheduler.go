@@ -0,0 +1,347 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dave/services/queue"
+)
+
+// TooManyItemsQueued is returned when the queue is full. It's the same value as the services queue
+// error, so existing checks continue to work.
+var TooManyItemsQueued = queue.TooManyItemsQueued
+
+// TooManyClientItemsQueued is returned when a single client has too many items waiting in the queue.
+var TooManyClientItemsQueued = errors.New("Sorry, you have too many items queued - try later.")
+
+// Draining is returned when the server is draining before shutting down.
+var Draining = errors.New("Sorry, this server is shutting down - retry on another instance.")
+
+// IsFlood returns true if the error was caused by the queue limits. These errors should not be stored
+// in the database, or a DOS would flood it.
+func IsFlood(err error) bool {
+	return err == TooManyItemsQueued || err == TooManyClientItemsQueued
+}
+
+// New creates a scheduler with the specified number of workers. Each lane is given a share of the
+// workers proportional to its weight. The queue is limited to max items, and to perClient items for
+// each client. The estimate duration is used for wait time estimates until a lane has finished a job.
+func New(workers, max, perClient int, weights map[string]int, estimate time.Duration) *Scheduler {
+	s := &Scheduler{
+		max:       max,
+		perClient: perClient,
+		workers:   workers,
+		lanes:     map[string]*lane{},
+		clients:   map[string]int{},
+		drained:   make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+	s.updated = sync.NewCond(&s.Mutex)
+	for name, weight := range weights {
+		s.lanes[name] = newLane(name, weight, estimate)
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	go s.notifier()
+	return s
+}
+
+// Scheduler is a queue of jobs with a lane for each endpoint. Lanes are served in proportion to their
+// weights (stride scheduling), and within a lane the clients are served round-robin so one client
+// can't monopolise the queue.
+type Scheduler struct {
+	sync.Mutex
+	cond      *sync.Cond
+	max       int
+	perClient int
+	workers   int
+	waiting   int
+	lanes     map[string]*lane
+	clients   map[string]int // number of waiting items for each client
+	pass      float64        // the pass of the most recently dispatched lane
+	draining  bool
+	drained   chan struct{} // closed by Drain
+	updates   []update      // updates waiting to be sent by notifier
+	updated   *sync.Cond    // signalled when updates are added
+}
+
+type lane struct {
+	name     string
+	stride   float64
+	pass     float64
+	clients  []string           // clients with waiting items, in round-robin order
+	items    map[string][]*item // waiting items for each client
+	duration time.Duration      // moving average of the job duration
+}
+
+type item struct {
+	lane, client string
+	log          func(position int, wait time.Duration)
+	start, end   chan struct{}
+	position     int
+	wait         time.Duration
+}
+
+// update is a change of the position or estimated wait of an item, sent by notifier.
+type update struct {
+	log      func(position int, wait time.Duration)
+	position int
+	wait     time.Duration
+}
+
+func newLane(name string, weight int, estimate time.Duration) *lane {
+	if weight < 1 {
+		weight = 1
+	}
+	return &lane{
+		name:     name,
+		stride:   1.0 / float64(weight),
+		items:    map[string][]*item{},
+		duration: estimate,
+	}
+}
+
+// Slot requests a slot in the lane for the client. The log function is called when the queue position
+// or the estimated wait changes. Execution of the work should not start until the start channel has been
+// closed. The end channel should be closed when work is finished (or abandoned). If the context is
+// cancelled before the slot becomes available, the item is removed from the queue.
+func (s *Scheduler) Slot(ctx context.Context, laneName, client string, log func(position int, wait time.Duration)) (start, end chan struct{}, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	l, ok := s.lanes[laneName]
+	if !ok {
+		return nil, nil, errors.New("unknown queue lane " + laneName)
+	}
+	if s.draining {
+		return nil, nil, Draining
+	}
+	if s.waiting >= s.max {
+		return nil, nil, TooManyItemsQueued
+	}
+	if s.perClient > 0 && s.clients[client] >= s.perClient {
+		return nil, nil, TooManyClientItemsQueued
+	}
+
+	i := &item{
+		lane:   laneName,
+		client: client,
+		log:    log,
+		start:  make(chan struct{}),
+		end:    make(chan struct{}),
+	}
+
+	if len(l.clients) == 0 && l.pass < s.pass {
+		// An idle lane shouldn't be able to build up credit while it has nothing queued.
+		l.pass = s.pass
+	}
+	if len(l.items[client]) == 0 {
+		l.clients = append(l.clients, client)
+	}
+	l.items[client] = append(l.items[client], i)
+	s.clients[client]++
+	s.waiting++
+
+	s.reposition()
+	s.cond.Signal()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.remove(i)
+		case <-i.start:
+		case <-s.drained:
+		}
+	}()
+
+	return i.start, i.end, nil
+}
+
+// remove removes an item from the queue, if it's still waiting.
+func (s *Scheduler) remove(i *item) {
+	s.Lock()
+	defer s.Unlock()
+	l := s.lanes[i.lane]
+	items := l.items[i.client]
+	index := -1
+	for n, other := range items {
+		if other == i {
+			index = n
+		}
+	}
+	if index == -1 {
+		// The item has already been dequeued, or the queue was drained.
+		return
+	}
+	l.items[i.client] = append(items[:index], items[index+1:]...)
+	if len(l.items[i.client]) == 0 {
+		delete(l.items, i.client)
+		for n, client := range l.clients {
+			if client == i.client {
+				l.clients = append(l.clients[:n], l.clients[n+1:]...)
+				break
+			}
+		}
+	}
+	s.clients[i.client]--
+	if s.clients[i.client] == 0 {
+		delete(s.clients, i.client)
+	}
+	s.waiting--
+	s.reposition()
+}
+
+// notifier sends the updates queued by reposition, in order. The log functions may block (e.g. sending
+// to a websocket), so they're called outside the lock.
+func (s *Scheduler) notifier() {
+	for {
+		s.Lock()
+		for len(s.updates) == 0 {
+			s.updated.Wait()
+		}
+		updates := s.updates
+		s.updates = nil
+		s.Unlock()
+
+		for _, u := range updates {
+			u.log(u.position, u.wait)
+		}
+	}
+}
+
+// Drain stops new work starting. Slot returns Draining, waiting items are discarded and the channel
+// returned by Draining is closed so the consumers waiting for a slot can give up. Work that has already
+// started isn't affected.
+func (s *Scheduler) Drain() {
+	s.Lock()
+	defer s.Unlock()
+	if s.draining {
+		return
+	}
+	s.draining = true
+	close(s.drained)
+	for _, l := range s.lanes {
+		l.clients = nil
+		l.items = map[string][]*item{}
+	}
+	s.clients = map[string]int{}
+	s.waiting = 0
+}
+
+// Draining returns a channel that's closed when Drain is called.
+func (s *Scheduler) Draining() <-chan struct{} {
+	return s.drained
+}
+
+func (s *Scheduler) worker() {
+	for {
+		s.Lock()
+		for s.waiting == 0 {
+			s.cond.Wait()
+		}
+		i := s.dequeue()
+		s.reposition()
+		s.Unlock()
+
+		select {
+		case <-i.end:
+			// The consumer gave up waiting before the slot became available, so skip it.
+			continue
+		case <-s.drained:
+			// The item was dequeued before Drain, but the consumer has been told to give up.
+			continue
+		default:
+		}
+
+		// we can close the start channel to signal to the consumer that it should start processing.
+		started := time.Now()
+		close(i.start)
+
+		// wait for the consumer to close the end channel
+		<-i.end
+
+		s.Lock()
+		l := s.lanes[i.lane]
+		l.duration = (l.duration*4 + time.Since(started)) / 5
+		s.Unlock()
+	}
+}
+
+// dequeue removes the next item from the queue. Must be called with the lock held, and only when items
+// are waiting.
+func (s *Scheduler) dequeue() *item {
+	l := s.nextLane()
+	client := l.clients[0]
+	i := l.items[client][0]
+
+	l.items[client] = l.items[client][1:]
+	l.clients = l.clients[1:]
+	if len(l.items[client]) > 0 {
+		// move the client to the back of the round-robin
+		l.clients = append(l.clients, client)
+	} else {
+		delete(l.items, client)
+	}
+	s.pass = l.pass
+	l.pass += l.stride
+
+	s.clients[client]--
+	if s.clients[client] == 0 {
+		delete(s.clients, client)
+	}
+	s.waiting--
+	return i
+}
+
+// nextLane returns the lane with waiting items that has the lowest pass. Ties are broken by name so the
+// order is deterministic.
+func (s *Scheduler) nextLane() *lane {
+	var next *lane
+	for _, l := range s.lanes {
+		if len(l.clients) == 0 {
+			continue
+		}
+		if next == nil || l.pass < next.pass || (l.pass == next.pass && l.name < next.name) {
+			next = l
+		}
+	}
+	return next
+}
+
+// reposition simulates the dispatch order of the waiting items and queues an update for all items
+// where the position or estimated wait has changed (see notifier). Must be called with the lock held.
+func (s *Scheduler) reposition() {
+
+	// copy the state of the lanes so we can simulate dequeueing
+	sim := &Scheduler{lanes: map[string]*lane{}, clients: map[string]int{}, waiting: s.waiting, pass: s.pass}
+	for name, l := range s.lanes {
+		c := *l
+		c.clients = append([]string(nil), l.clients...)
+		c.items = map[string][]*item{}
+		for client, items := range l.items {
+			c.items[client] = append([]*item(nil), items...)
+		}
+		sim.lanes[name] = &c
+	}
+	for client, count := range s.clients {
+		sim.clients[client] = count
+	}
+
+	var ahead time.Duration
+	for position := 1; sim.waiting > 0; position++ {
+		i := sim.dequeue()
+		wait := ahead / time.Duration(s.workers)
+		if i.position != position || i.wait.Round(time.Second) != wait.Round(time.Second) {
+			i.position = position
+			i.wait = wait
+			s.updates = append(s.updates, update{log: i.log, position: position, wait: wait})
+		}
+		ahead += s.lanes[i.lane].duration
+	}
+	if len(s.updates) > 0 {
+		s.updated.Signal()
+	}
+}
@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// block fills the single worker of the scheduler with an item, and returns its end channel.
+func block(t *testing.T, s *Scheduler, lane string) chan struct{} {
+	start, end, err := s.Slot(context.Background(), lane, "blocker", func(int, time.Duration) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-start
+	return end
+}
+
+// run queues items in order, then unblocks the worker and returns the order they were started in.
+func run(t *testing.T, s *Scheduler, blocked chan struct{}, items [][2]string) []string {
+	started := make(chan string)
+	for _, item := range items {
+		start, end, err := s.Slot(context.Background(), item[0], item[1], func(int, time.Duration) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(name string) {
+			<-start
+			started <- name
+			close(end)
+		}(item[0] + "/" + item[1])
+	}
+	close(blocked)
+	var order []string
+	for range items {
+		select {
+		case name := <-started:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %v", order)
+		}
+	}
+	return order
+}
+
+func TestLanes(t *testing.T) {
+	s := New(1, 100, 0, map[string]int{"a": 2, "b": 1}, time.Second)
+	blocked := block(t, s, "a")
+	var items [][2]string
+	for i := 0; i < 6; i++ {
+		items = append(items, [2]string{"a", "x"}, [2]string{"b", "x"})
+	}
+	order := run(t, s, blocked, items)
+
+	// While both lanes have items, a is served twice as often as b.
+	count := map[string]int{}
+	for _, name := range order[:6] {
+		count[name]++
+	}
+	if count["a/x"] != 4 || count["b/x"] != 2 {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
+
+func TestClients(t *testing.T) {
+	s := New(1, 100, 0, map[string]int{"a": 1}, time.Second)
+	blocked := block(t, s, "a")
+	order := run(t, s, blocked, [][2]string{{"a", "x"}, {"a", "x"}, {"a", "x"}, {"a", "y"}})
+
+	// The clients are served round-robin, so y doesn't wait for all of x's items.
+	expected := []string{"a/x", "a/y", "a/x", "a/x"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected order %v", order)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := New(1, 3, 2, map[string]int{"a": 1}, time.Second)
+	blocked := block(t, s, "a")
+	defer close(blocked)
+
+	slot := func(ctx context.Context, client string) error {
+		_, _, err := s.Slot(ctx, "a", client, func(int, time.Duration) {})
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, err := range []error{slot(ctx, "x"), slot(context.Background(), "x")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := slot(context.Background(), "x"); err != TooManyClientItemsQueued {
+		t.Fatalf("expected TooManyClientItemsQueued, got %v", err)
+	}
+	if err := slot(context.Background(), "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := slot(context.Background(), "z"); err != TooManyItemsQueued {
+		t.Fatalf("expected TooManyItemsQueued, got %v", err)
+	}
+
+	// A cancelled item is removed from the queue, so it no longer counts against the limits.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := slot(context.Background(), "x")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cancelled item not removed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	s := New(1, 100, 0, map[string]int{"a": 1}, time.Second)
+	blocked := block(t, s, "a")
+	defer close(blocked)
+
+	positions := make(chan int, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := s.Slot(ctx, "a", "x", func(int, time.Duration) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Slot(context.Background(), "a", "y", func(position int, wait time.Duration) {
+		positions <- position
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{2, 1} {
+		select {
+		case position := <-positions:
+			if position != expected {
+				t.Fatalf("got position %d, expected %d", position, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for position %d", expected)
+		}
+		// Cancelling the item in front moves y up the queue.
+		cancel()
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := New(1, 100, 0, map[string]int{"a": 1}, time.Second)
+	blocked := block(t, s, "a")
+	start, _, err := s.Slot(context.Background(), "a", "x", func(int, time.Duration) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Drain()
+	select {
+	case <-s.Draining():
+	default:
+		t.Fatal("expected Draining to be closed")
+	}
+	if _, _, err := s.Slot(context.Background(), "a", "x", func(int, time.Duration) {}); err != Draining {
+		t.Fatalf("expected Draining, got %v", err)
+	}
+
+	// The running item isn't affected, and the waiting item is never started.
+	close(blocked)
+	select {
+	case <-start:
+		t.Fatal("waiting item started after drain")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
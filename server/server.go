@@ -6,7 +6,10 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/user"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -22,10 +25,23 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/dave/jsgo/assets"
 	"github.com/dave/jsgo/config"
+	"github.com/dave/jsgo/server/admin"
+	_ "github.com/dave/jsgo/server/codec/msgpack" // registers the msgpack codec
+	"github.com/dave/jsgo/server/dsstore"
 	"github.com/dave/jsgo/server/frizz"
+	"github.com/dave/jsgo/server/jobs"
 	"github.com/dave/jsgo/server/jsgo"
+	"github.com/dave/jsgo/server/limiter"
+	"github.com/dave/jsgo/server/logger"
+	"github.com/dave/jsgo/server/metrics"
+	"github.com/dave/jsgo/server/pin"
 	"github.com/dave/jsgo/server/play"
+	"github.com/dave/jsgo/server/s3"
+	"github.com/dave/jsgo/server/scheduler"
+	"github.com/dave/jsgo/server/sqldatabase"
 	"github.com/dave/jsgo/server/store"
+	"github.com/dave/jsgo/server/trace"
+	"github.com/dave/jsgo/server/uploads"
 	"github.com/dave/jsgo/server/wasm"
 	"github.com/dave/patsy"
 	"github.com/dave/patsy/vos"
@@ -38,76 +54,119 @@ import (
 	"github.com/dave/services/fileserver/gcsfileserver"
 	"github.com/dave/services/fileserver/localfileserver"
 	"github.com/dave/services/getter/cache"
-	"github.com/dave/services/queue"
 	"github.com/dave/services/tracker"
-	"github.com/gorilla/websocket"
 	"github.com/shurcooL/httpgzip"
 	"gopkg.in/src-d/go-billy.v4"
 )
 
-func init() {
-	assets.Init()
+// Options selects the storage. The zero value uses GCS and the cloud datastore, or the local fileserver
+// and database in local mode.
+type Options struct {
+	Objects  *s3.Client            // S3-compatible object store for files
+	Database *sqldatabase.Database // SQL database for entities
 }
 
-func New(shutdown chan struct{}) *Handler {
+func New(shutdown chan struct{}, options Options) *Handler {
+	// The assets are loaded here rather than in init because where they're loaded from depends on the
+	// configuration (config.DEV and the object store), which main applies before calling New.
+	assets.Init(options.Objects)
+
 	var c *cache.Cache
 	var fileserver services.Fileserver
 	var database services.Database
+	var querier store.Querier
 	if config.LOCAL {
-		fileserver = localfileserver.New(config.LocalFileserverTempDir, config.Static, config.Host, config.Bucket)
-		database = localdatabase.New(config.LocalFileserverTempDir)
+		if options.Objects != nil {
+			fileserver = s3Fileserver(options.Objects)
+		} else {
+			fileserver = uploads.New(
+				trace.NewFileserver(metrics.NewFileserver(localfileserver.New(config.LocalFileserverTempDir, config.Static, config.Host, config.Bucket))),
+				localDelete(expandHome(config.LocalFileserverTempDir)),
+			)
+		}
+		if options.Database != nil {
+			database, querier = options.Database, options.Database
+		} else {
+			database = localdatabase.New(config.LocalFileserverTempDir)
+			querier = store.NewLocalQuerier(expandHome(config.LocalFileserverTempDir))
+		}
 		fetcherResolver, err := localfetcher.New()
 		if err != nil {
 			panic(err)
 		}
 		c = cache.New(
-			database,
-			fetcherResolver,
+			metrics.NewDatabase(database, config.HintsKind),
+			pin.New(fetcherResolver),
 			fetcherResolver,
 			config.HintsKind,
 		)
 	} else {
-		storageClient, err := storage.NewClient(context.Background())
-		if err != nil {
-			panic(err)
+		if options.Database != nil {
+			database, querier = options.Database, options.Database
+		} else {
+			datastoreClient, err := datastore.NewClient(context.Background(), config.ProjectID)
+			if err != nil {
+				panic(err)
+			}
+			gcsDatabase := gcsdatabase.New(datastoreClient)
+			database, querier = gcsDatabase, dsstore.NewGcsQuerier(gcsDatabase)
 		}
-
-		datastoreClient, err := datastore.NewClient(context.Background(), config.ProjectID)
-		if err != nil {
-			panic(err)
+		if options.Objects != nil {
+			fileserver = s3Fileserver(options.Objects)
+		} else {
+			storageClient, err := storage.NewClient(context.Background())
+			if err != nil {
+				panic(err)
+			}
+			fileserver = uploads.New(
+				trace.NewFileserver(metrics.NewFileserver(gcsfileserver.New(storageClient, config.Buckets))),
+				func(ctx context.Context, bucket, name string) error {
+					return storageClient.Bucket(bucket).Object(name).Delete(ctx)
+				},
+			)
 		}
-
-		database = gcsdatabase.New(datastoreClient)
-		fileserver = gcsfileserver.New(storageClient, config.Buckets)
 		c = cache.New(
-			database,
-			gitfetcher.New(
+			metrics.NewDatabase(database, config.HintsKind),
+			pin.New(gitfetcher.New(
 				cachefileserver.New(1024*1024*1042, 100*1024*1024),
 				fileserver,
 				config.GitFetcherConfig,
-			),
+			)),
 			nil,
 			config.HintsKind,
 		)
 	}
 	h := &Handler{
-		mux:        http.NewServeMux(),
-		shutdown:   shutdown,
-		Queue:      queue.New(config.MaxConcurrentCompiles, config.MaxQueue),
-		Waitgroup:  &sync.WaitGroup{},
-		Cache:      c,
-		Fileserver: fileserver,
-		Database:   database,
+		mux:          http.NewServeMux(),
+		shutdown:     shutdown,
+		Queue:        scheduler.New(config.MaxConcurrentCompiles, config.MaxQueue, config.MaxQueuePerClient, config.QueueWeights, config.QueueEstimate),
+		Jobs:         jobs.New(),
+		SocketLimits: limiter.New(config.SocketRateLimits),
+		PageLimits:   limiter.New(config.PageRateLimits),
+		Origins:      NewOrigins(config.Host, config.Protocol, config.SocketOrigins, config.EmbedOrigins),
+		Router:       NewRouter(config.Host, config.Pages),
+		Waitgroup:    &sync.WaitGroup{},
+		Cache:        c,
+		Fileserver:   fileserver,
+		Database:     dsstore.New(database),
+		Log:          logger.Default,
+		Admin:        &admin.Handler{Querier: querier},
 	}
 	h.mux.HandleFunc("/", h.PageHandler)
 	h.mux.HandleFunc("/_script.js", h.ScriptHandler)
 	h.mux.HandleFunc("/_script.js.map", h.ScriptHandler)
 	h.mux.HandleFunc("/_info/", tracker.Handler)
+	h.mux.Handle("/metrics", h.Admin.Protect(http.HandlerFunc(metrics.Handler)))
+	h.mux.Handle("/_admin/", h.Admin)
 
-	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(&jsgo.Handler{h.Cache, h.Fileserver, h.Database}))
-	h.mux.HandleFunc("/_play/", h.SocketHandler(&play.Handler{h.Cache, h.Fileserver, h.Database}))
-	h.mux.HandleFunc("/_frizz/", h.SocketHandler(&frizz.Handler{h.Cache, h.Fileserver, h.Database}))
-	h.mux.HandleFunc("/_wasm/", h.SocketHandler(&wasm.Handler{h.Cache, h.Fileserver, h.Database}))
+	jsgoHandler := &jsgo.Handler{Cache: h.Cache, Fileserver: h.Fileserver, Database: h.Database, Log: h.Log}
+
+	h.mux.HandleFunc("/_jsgo/", h.SocketHandler(jsgoHandler))
+	h.mux.HandleFunc("/_play/", h.SocketHandler(&play.Handler{Cache: h.Cache, Fileserver: h.Fileserver, Database: h.Database, Log: h.Log}))
+	h.mux.HandleFunc("/_frizz/", h.SocketHandler(&frizz.Handler{Cache: h.Cache, Fileserver: h.Fileserver, Database: h.Database, Log: h.Log}))
+	h.mux.HandleFunc("/_wasm/", h.SocketHandler(&wasm.Handler{Cache: h.Cache, Fileserver: h.Fileserver, Database: h.Database, Log: h.Log}))
+
+	h.mux.HandleFunc("/_api/compile", h.CompileApiHandler(jsgoHandler))
 
 	//h.mux.HandleFunc("/_ws/", h.SocketHandler)
 	//h.mux.HandleFunc("/_pg/", h.SocketHandler)
@@ -125,26 +184,60 @@ func New(shutdown chan struct{}) *Handler {
 }
 
 type Handler struct {
-	Cache      *cache.Cache
-	Fileserver services.Fileserver
-	Database   services.Database
-	Waitgroup  *sync.WaitGroup
-	Queue      *queue.Queue
-	mux        *http.ServeMux
-	shutdown   chan struct{}
+	Cache        *cache.Cache
+	Fileserver   services.Fileserver
+	Database     store.Database
+	Waitgroup    *sync.WaitGroup
+	Queue        *scheduler.Scheduler
+	Jobs         *jobs.Registry
+	SocketLimits *limiter.Set
+	PageLimits   *limiter.Set
+	Origins      Origins
+	Router       *Router
+	Log          *logger.Logger
+	Admin        *admin.Handler
+	mux          *http.ServeMux
+	shutdown     chan struct{}
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// s3Fileserver stores files in an S3 compatible object store, and deletes the files a cancelled job
+// created (see uploads).
+func s3Fileserver(client *s3.Client) services.Fileserver {
+	return uploads.New(trace.NewFileserver(metrics.NewFileserver(s3.NewFileserver(client))), client.Delete)
+}
+
+// expandHome expands a leading "~" in dir to the home directory.
+func expandHome(dir string) string {
+	if strings.HasPrefix(dir, "~") {
+		home := os.Getenv("HOME")
+		if home == "" {
+			u, err := user.Current()
+			if err != nil {
+				panic(err)
+			}
+			home = u.HomeDir
+		}
+		dir = filepath.Join(home, strings.TrimPrefix(dir, "~"))
+	}
+	return dir
+}
+
+// localDelete deletes files from the local fileserver, which stores each bucket in a directory.
+func localDelete(dir string) uploads.DeleteFunc {
+	return func(ctx context.Context, bucket, name string) error {
+		return os.Remove(filepath.Join(dir, bucket, url.PathEscape(name)))
+	}
 }
 
 func (h *Handler) storeError(ctx context.Context, err error, req *http.Request) {
 
-	if err == queue.TooManyItemsQueued {
+	if scheduler.IsFlood(err) {
 		// If the server is getting flooded by a DOS, this will prevent database flooding
 		return
 	}
 
+	logger.FromContext(ctx, h.Log).Error("request failed", "error", err)
+
 	// ignore errors when logging an error
 	store.StoreError(ctx, h.Database, store.Error{
 		Time:  time.Now(),
@@ -167,9 +260,22 @@ func (h *Handler) CssHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (h *Handler) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-h.Queue.Draining():
+		// Fail the health check so the load balancer stops sending clients to this instance.
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	default:
+	}
 	fmt.Fprint(w, "ok")
 }
 
+// Drain stops the server admitting new jobs and fails the health check. Running jobs continue until
+// they finish, or until the shutdown channel is closed.
+func (h *Handler) Drain() {
+	h.Queue.Drain()
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
@@ -0,0 +1,161 @@
+package servermsg
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Phases of the compile pipeline.
+const (
+	PhaseDownload = "download"
+	PhaseBuild    = "build"
+	PhaseStore    = "store"
+)
+
+// Error codes sent in Error.Code, so clients don't have to match the message.
+const (
+	CodeInternal               = "internal"
+	CodeInvalidRequest         = "invalid_request"
+	CodeJobNotFound            = "job_not_found"
+	CodeRateLimited            = "rate_limited"
+	CodeQueueFull              = "queue_full"
+	CodeTimeout                = "timeout"
+	CodeShutdown               = "shutdown"
+	CodeDraining               = "draining" // The server is shutting down, so retry on another instance
+	CodePanic                  = "panic"
+	CodeTooManyGitObjects      = "too_many_git_objects"
+	CodeUnrecognizedImportPath = "unrecognized_import_path"
+	CodePackageNotFound        = "package_not_found"
+	CodeNoGoFiles              = "no_go_files"
+	CodeChecksumMismatch       = "checksum_mismatch"
+	CodeFetchFailed            = "fetch_failed"
+	CodeCompileError           = "compile_error"
+	CodeBuildFailed            = "build_failed"
+	CodeStorageFailed          = "storage_failed"
+	CodeVersionNotSupported    = "version_not_supported"
+)
+
+// Position is the source position of a compile error.
+type Position struct {
+	Package string // Empty if the file isn't in GOPATH or GOROOT
+	File    string // e.g. gopath/src/github.com/foo/bar/bar.go
+	Line    int
+	Column  int // Zero if unknown
+	Message string
+}
+
+// Failure is returned by the steps of a handler, so the Error sent to the client has the phase and
+// package that failed.
+type Failure struct {
+	Code  string // Optional - if empty, the code is chosen from the error message
+	Phase string
+	Path  string
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	return f.Err.Error()
+}
+
+// Failed wraps an error from a step of the pipeline. Nil errors and errors that are already wrapped are
+// returned unchanged.
+func Failed(phase, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Failure); ok {
+		return err
+	}
+	return &Failure{Phase: phase, Path: path, Err: err}
+}
+
+// Invalid wraps an error caused by an invalid request from the client.
+func Invalid(err error) error {
+	return &Failure{Code: CodeInvalidRequest, Err: err}
+}
+
+var (
+	quotedPackage = regexp.MustCompile(`(?:cannot find package|unrecognized import path) "([^"]+)"`)
+
+	// errorPosition matches errors in compiler output e.g. "gopath/src/a/b/c.go:10:5: undefined: x"
+	errorPosition = regexp.MustCompile(`^\s*(\S+\.go):(\d+)(?::(\d+))?:\s*(.*)$`)
+)
+
+// NewError creates the Error sent to the client from an error returned by a handler.
+func NewError(err error) Error {
+	e := Error{Message: err.Error()}
+	if f, ok := err.(*Failure); ok {
+		e.Code, e.Phase, e.Path, err = f.Code, f.Phase, f.Path, f.Err
+	}
+	e.Positions = ParsePositions(e.Message)
+	if m := quotedPackage.FindStringSubmatch(e.Message); m != nil {
+		e.Path = m[1]
+	} else if len(e.Positions) > 0 && e.Positions[0].Package != "" {
+		e.Path = e.Positions[0].Package
+	}
+	if e.Code == "" {
+		e.Code = classify(err, e.Phase, len(e.Positions) > 0)
+	}
+	switch e.Code {
+	case CodeTimeout, CodeShutdown, CodeDraining, CodeQueueFull, CodeRateLimited, CodeFetchFailed, CodeStorageFailed:
+		e.Retryable = true
+	}
+	return e
+}
+
+func classify(err error, phase string, positions bool) string {
+	message := err.Error()
+	switch {
+	case err == context.DeadlineExceeded:
+		return CodeTimeout
+	case strings.Contains(message, "too many git objects"):
+		return CodeTooManyGitObjects
+	case strings.Contains(message, "unrecognized import path"):
+		return CodeUnrecognizedImportPath
+	case strings.Contains(message, "cannot find package"):
+		return CodePackageNotFound
+	case strings.Contains(message, "no Go files in"), strings.Contains(message, "no buildable Go source files"):
+		return CodeNoGoFiles
+	case strings.Contains(message, "checksum mismatch"):
+		return CodeChecksumMismatch
+	case positions:
+		return CodeCompileError
+	}
+	switch phase {
+	case PhaseDownload:
+		return CodeFetchFailed
+	case PhaseBuild:
+		return CodeBuildFailed
+	case PhaseStore:
+		return CodeStorageFailed
+	}
+	return CodeInternal
+}
+
+// ParsePositions finds the errors with source positions in compiler output.
+func ParsePositions(output string) []Position {
+	var positions []Position
+	for _, line := range strings.Split(output, "\n") {
+		matches := errorPosition.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		p := Position{
+			File:    strings.TrimPrefix(matches[1], "/"),
+			Message: matches[4],
+		}
+		p.Line, _ = strconv.Atoi(matches[2])
+		p.Column, _ = strconv.Atoi(matches[3])
+		dir := filepath.Dir(p.File)
+		for _, root := range []string{"gopath/src/", "goroot/src/"} {
+			if strings.HasPrefix(dir, root) {
+				p.Package = strings.TrimPrefix(dir, root)
+			}
+		}
+		positions = append(positions, p)
+	}
+	return positions
+}
@@ -1,17 +1,77 @@
 package servermsg
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+
+	"github.com/dave/services"
+)
 
 func RegisterTypes() {
 	gob.Register(Queueing{})
 	gob.Register(Error{})
+	gob.Register(Job{})
+	gob.Register(Session{})
+	gob.Register(Finished{})
+	gob.Register(Cancel{})
+	gob.Register(Cancelled{})
+	gob.Register(Trace{})
 }
 
 type Queueing struct {
+	Lane     string // Queue lane (jsgo, play, frizz or wasm)
 	Position int
+	Wait     int // Estimated wait in seconds
 	Done     bool
 }
 
 type Error struct {
-	Message string
+	Message    string
+	Code       string     // See CodeInternal etc.
+	Phase      string     // Phase that failed (see PhaseDownload etc.), if any
+	Path       string     // Package that failed, if known
+	Positions  []Position // Source positions of compile errors
+	Retryable  bool       // The same request may succeed if it's retried
+	RetryAfter int        // If non-zero, the request was rejected by the rate limiter and may be retried after this many seconds
+}
+
+// Job is the first message sent for each job. If the connection drops, the client can reconnect with
+// ?job=<id> to replay missed messages and continue receiving new ones.
+type Job struct {
+	ID string
 }
+
+// Trace is sent when a job starts. The ID identifies the trace of the job in the tracing backend, so
+// users can include it in bug reports.
+type Trace struct {
+	ID string
+}
+
+// Session is the first message sent on a connection opened with ?session. The connection accepts any
+// number of commands, each sent with an ID, and the messages sent in response have the same ID.
+type Session struct{}
+
+// Command is a message from the client in a session. The codecs encode the ID in the envelope of the
+// message, so clients don't send a Command message.
+type Command struct {
+	ID      string
+	Message services.Message
+}
+
+// Reply is a message sent in response to a command in a session. The codecs encode the ID in the
+// envelope of the message.
+type Reply struct {
+	ID      string
+	Message services.Message
+}
+
+// Finished is the last message sent in response to a command in a session, after the Error if the
+// command failed.
+type Finished struct{}
+
+// Cancel is sent by the client to cancel the job. Files stored by the job are deleted and the job
+// finishes with Cancelled instead of an Error. In a session, Cancel is sent with the ID of the command.
+type Cancel struct{}
+
+// Cancelled is sent when a job is cancelled by the client. If the job finished before the Cancel was
+// received, the usual messages are sent instead.
+type Cancelled struct{}
@@ -0,0 +1,51 @@
+// Package sessions keeps a session.Session warm between the commands sent on a multi-command websocket
+// connection, so packages downloaded by one command don't have to be downloaded again by the next.
+package sessions
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/dave/jsgo/assets"
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+	"github.com/dave/services/session"
+)
+
+type cacheKeyType struct{}
+
+var cacheKey = cacheKeyType{}
+
+// Cache holds the warm sessions of a connection, one for each set of build tags.
+type Cache struct {
+	m        sync.Mutex
+	sessions map[string]*session.Session
+}
+
+// NewContext returns a context that carries a new cache.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheKey, &Cache{sessions: map[string]*session.Session{}})
+}
+
+// Get returns the warm session for the build tags if the context carries a cache, or a new session if
+// it doesn't.
+func Get(ctx context.Context, tags []string, fileserver services.Fileserver) *session.Session {
+	c, ok := ctx.Value(cacheKey).(*Cache)
+	if !ok {
+		return session.New(tags, assets.Assets, assets.Archives, fileserver, config.ValidExtensions)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	key := strings.Join(tags, ",")
+	s, ok := c.sessions[key]
+	if !ok {
+		if len(c.sessions) >= config.SessionWarmLimit {
+			// Drop the warm sessions rather than keep an unbounded amount of source in memory.
+			c.sessions = map[string]*session.Session{}
+		}
+		s = session.New(tags, assets.Assets, assets.Archives, fileserver, config.ValidExtensions)
+		c.sessions[key] = s
+	}
+	return s
+}
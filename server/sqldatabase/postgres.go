@@ -0,0 +1,7 @@
+// +build postgres
+
+package sqldatabase
+
+import (
+	_ "github.com/lib/pq" // registers the postgres driver
+)
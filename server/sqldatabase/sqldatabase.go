@@ -0,0 +1,482 @@
+// Package sqldatabase implements services.Database on SQLite or Postgres. Each kind has a table with
+// the key of the entity, its Time, Path and Ip fields (indexed for the admin queries) and the entity
+// encoded as JSON.
+//
+// The drivers aren't imported by default: build with the sqlite tag (github.com/mattn/go-sqlite3) or
+// the postgres tag (github.com/lib/pq).
+package sqldatabase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/dave/jsgo/server/dsstore"
+	"github.com/dave/jsgo/server/store"
+)
+
+// Dialect is the SQL dialect of the database.
+type Dialect int
+
+const (
+	Sqlite Dialect = iota
+	Postgres
+)
+
+// DialectOf returns the dialect of a database/sql driver name.
+func DialectOf(driver string) (Dialect, error) {
+	switch driver {
+	case "sqlite3":
+		return Sqlite, nil
+	case "postgres":
+		return Postgres, nil
+	}
+	return 0, fmt.Errorf("unsupported database driver %q", driver)
+}
+
+// Open opens the database with the driver ("sqlite3" or "postgres") and creates the tables.
+func Open(ctx context.Context, driver, source string, kinds []string) (*Database, error) {
+	dialect, err := DialectOf(driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	d := New(db, dialect, kinds)
+	if err := d.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// New creates a database with a table for each of the kinds. Call Migrate to create the tables.
+func New(db *sql.DB, dialect Dialect, kinds []string) *Database {
+	d := &Database{db: db, dialect: dialect, kinds: map[string]bool{}}
+	for _, kind := range kinds {
+		d.kinds[kind] = true
+	}
+	return d
+}
+
+type Database struct {
+	db      *sql.DB
+	dialect Dialect
+	kinds   map[string]bool
+}
+
+// Migrate creates the tables, columns and indexes that don't exist.
+func (d *Database) Migrate(ctx context.Context) error {
+	for kind := range d.kinds {
+		table := quote(kind)
+		// The key is either an ID (with an empty name) or a name (with a zero ID). Time is the Time
+		// field of the entity in Unix nanoseconds, and path and ip its Path and Ip fields, or null if it
+		// has none.
+		create := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			id BIGINT NOT NULL,
+			name TEXT NOT NULL,
+			time BIGINT,
+			path TEXT,
+			ip TEXT,
+			data TEXT NOT NULL,
+			PRIMARY KEY (id, name)
+		)`
+		if _, err := d.db.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("migrating %s: %v", kind, err)
+		}
+		if err := d.addColumns(ctx, kind); err != nil {
+			return fmt.Errorf("migrating %s: %v", kind, err)
+		}
+		indexes := []string{
+			`CREATE INDEX IF NOT EXISTS ` + quote(kind+"_time") + ` ON ` + table + ` (time)`,
+			`CREATE INDEX IF NOT EXISTS ` + quote(kind+"_path") + ` ON ` + table + ` (path, time)`,
+			`CREATE INDEX IF NOT EXISTS ` + quote(kind+"_ip") + ` ON ` + table + ` (ip, time)`,
+		}
+		for _, s := range indexes {
+			if _, err := d.db.ExecContext(ctx, s); err != nil {
+				return fmt.Errorf("migrating %s: %v", kind, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addColumns adds the path and ip columns to a table created before they existed, and fills them in
+// from the stored entities.
+func (d *Database) addColumns(ctx context.Context, kind string) error {
+	table := quote(kind)
+	rows, err := d.db.QueryContext(ctx, `SELECT path, ip FROM `+table+` WHERE 1 = 0`)
+	if err == nil {
+		rows.Close()
+		return nil
+	}
+	for _, s := range []string{
+		`ALTER TABLE ` + table + ` ADD COLUMN path TEXT`,
+		`ALTER TABLE ` + table + ` ADD COLUMN ip TEXT`,
+	} {
+		if _, err := d.db.ExecContext(ctx, s); err != nil {
+			return err
+		}
+	}
+	rows, err = d.db.QueryContext(ctx, `SELECT id, name, data FROM `+table)
+	if err != nil {
+		return err
+	}
+	type entity struct {
+		key  store.Key
+		path interface{}
+		ip   interface{}
+	}
+	var entities []entity
+	err = scan(rows, kind, func(k store.Key, data []byte) error {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		path, ip := fieldsOf(fields)
+		entities = append(entities, entity{key: k, path: path, ip: ip})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entities {
+		update := d.rebind(`UPDATE ` + table + ` SET path = ?, ip = ? WHERE id = ? AND name = ?`)
+		if _, err := d.db.ExecContext(ctx, update, e.path, e.ip, e.key.ID, e.key.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Database) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	k := dsstore.FromDatastoreKey(key)
+	if k.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	if err := d.check(k.Kind); err != nil {
+		return err
+	}
+	var data string
+	row := d.db.QueryRowContext(ctx, d.rebind(`SELECT data FROM `+quote(k.Kind)+` WHERE id = ? AND name = ?`), k.ID, k.Name)
+	if err := row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return datastore.ErrNoSuchEntity
+		}
+		return err
+	}
+	return json.Unmarshal([]byte(data), dst)
+}
+
+func (d *Database) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	k, err := d.put(ctx, d.db, dsstore.FromDatastoreKey(key), src)
+	if err != nil {
+		return nil, err
+	}
+	return dsstore.DatastoreKey(k), nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (d *Database) put(ctx context.Context, db execer, k store.Key, src interface{}) (store.Key, error) {
+	if err := d.check(k.Kind); err != nil {
+		return store.Key{}, err
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return store.Key{}, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return store.Key{}, err
+	}
+	path, ip := fieldsOf(fields)
+	if !k.Incomplete() {
+		_, err = db.ExecContext(ctx, d.rebind(`
+			INSERT INTO `+quote(k.Kind)+` (id, name, time, path, ip, data) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id, name) DO UPDATE SET time = excluded.time, path = excluded.path, ip = excluded.ip, data = excluded.data
+		`), k.ID, k.Name, timeOf(src), path, ip, string(data))
+		if err != nil {
+			return store.Key{}, err
+		}
+		return k, nil
+	}
+	// Like the datastore, new entities get a random ID. The insert does nothing if the ID is taken, so
+	// a collision is retried with another ID rather than overwriting the other entity.
+	for i := 0; i < idAttempts; i++ {
+		k.ID = newID()
+		result, err := db.ExecContext(ctx, d.rebind(`
+			INSERT INTO `+quote(k.Kind)+` (id, name, time, path, ip, data) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id, name) DO NOTHING
+		`), k.ID, k.Name, timeOf(src), path, ip, string(data))
+		if err != nil {
+			return store.Key{}, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return store.Key{}, err
+		}
+		if n == 1 {
+			return k, nil
+		}
+	}
+	return store.Key{}, fmt.Errorf("no unused ID for %s after %d attempts", k.Kind, idAttempts)
+}
+
+// idAttempts is the number of random IDs tried for a new entity.
+const idAttempts = 10
+
+// newID returns a random non-zero ID (a zero ID would make an incomplete key).
+var newID = func() int64 {
+	return rand.Int63n(math.MaxInt64-1) + 1
+}
+
+// GetMulti gets the entities with one query for each kind. Missing entities are reported in a
+// datastore.MultiError, as they are by the datastore.
+func (d *Database) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice {
+		return errors.New("datastore: dst has invalid type")
+	}
+	if len(keys) != v.Len() {
+		return errors.New("datastore: keys and dst slices have different length")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// The positions of each key in keys, and the distinct keys of each kind.
+	positions := map[store.Key][]int{}
+	byKind := map[string][]store.Key{}
+	for i, key := range keys {
+		k := dsstore.FromDatastoreKey(key)
+		if k.Incomplete() {
+			return datastore.ErrInvalidKey
+		}
+		if err := d.check(k.Kind); err != nil {
+			return err
+		}
+		if _, ok := positions[k]; !ok {
+			byKind[k.Kind] = append(byKind[k.Kind], k)
+		}
+		positions[k] = append(positions[k], i)
+	}
+
+	found := make([]bool, len(keys))
+	for kind, kindKeys := range byKind {
+		var conditions []string
+		var args []interface{}
+		for _, k := range kindKeys {
+			conditions = append(conditions, "(id = ? AND name = ?)")
+			args = append(args, k.ID, k.Name)
+		}
+		query := `SELECT id, name, data FROM ` + quote(kind) + ` WHERE ` + strings.Join(conditions, " OR ")
+		rows, err := d.db.QueryContext(ctx, d.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		err = scan(rows, kind, func(k store.Key, data []byte) error {
+			for _, i := range positions[k] {
+				found[i] = true
+				if err := json.Unmarshal(data, element(v, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	multi := make(datastore.MultiError, len(keys))
+	var missing bool
+	for i := range keys {
+		if !found[i] {
+			multi[i] = datastore.ErrNoSuchEntity
+			missing = true
+		}
+	}
+	if missing {
+		return multi
+	}
+	return nil
+}
+
+// PutMulti stores the entities in a transaction.
+func (d *Database) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) (_ []*datastore.Key, err error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice {
+		return nil, errors.New("datastore: src has invalid type")
+	}
+	if len(keys) != v.Len() {
+		return nil, errors.New("datastore: key and src slices have different length")
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	out := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		k, err := d.put(ctx, tx, dsstore.FromDatastoreKey(key), v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		out[i] = dsstore.DatastoreKey(k)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Recent implements store.Querier using the indexes on time and ip.
+func (d *Database) Recent(ctx context.Context, kind string, q store.Query, dst interface{}) ([]store.Key, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("dst must be a pointer to a slice")
+	}
+	if err := d.check(kind); err != nil {
+		return nil, err
+	}
+	slice := v.Elem()
+	conditions := []string{"time >= ?"}
+	args := []interface{}{q.Since.UnixNano()}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "time <= ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.Ip != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, q.Ip)
+	}
+	query := `SELECT id, name, data FROM ` + quote(kind) + ` WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY time DESC LIMIT ?`
+	rows, err := d.db.QueryContext(ctx, d.rebind(query), append(args, q.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	var keys []store.Key
+	err = scan(rows, kind, func(k store.Key, data []byte) error {
+		e := reflect.New(slice.Type().Elem())
+		if err := json.Unmarshal(data, e.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, e.Elem()))
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// scan calls f for each row of id, name and data, and closes the rows.
+func scan(rows *sql.Rows, kind string, f func(k store.Key, data []byte) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		k := store.Key{Kind: kind}
+		var data string
+		if err := rows.Scan(&k.ID, &k.Name, &data); err != nil {
+			return err
+		}
+		if err := f(k, []byte(data)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// check returns an error if the kind has no table.
+func (d *Database) check(kind string) error {
+	if !d.kinds[kind] {
+		return fmt.Errorf("no table for kind %q", kind)
+	}
+	return nil
+}
+
+// rebind replaces the ? placeholders with $1, $2 etc. for Postgres.
+func (d *Database) rebind(query string) string {
+	if d.dialect != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// quote quotes an identifier. Kinds are checked against the known kinds before they're used in a query.
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// element returns a pointer to decode element i of the slice into.
+func element(slice reflect.Value, i int) interface{} {
+	e := slice.Index(i)
+	if e.Kind() == reflect.Ptr {
+		if e.IsNil() {
+			e.Set(reflect.New(e.Type().Elem()))
+		}
+		return e.Interface()
+	}
+	return e.Addr().Interface()
+}
+
+// fieldsOf returns the Path and Ip fields of an entity decoded from JSON, or nil for those it doesn't
+// have.
+func fieldsOf(fields map[string]interface{}) (path, ip interface{}) {
+	if s, ok := fields["Path"].(string); ok {
+		path = s
+	}
+	if s, ok := fields["Ip"].(string); ok {
+		ip = s
+	}
+	return path, ip
+}
+
+// timeOf returns the Time field of the entity in Unix nanoseconds, or nil if it has none.
+func timeOf(src interface{}) interface{} {
+	v := reflect.Indirect(reflect.ValueOf(src))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.FieldByName("Time")
+	if !f.IsValid() {
+		return nil
+	}
+	t, ok := f.Interface().(time.Time)
+	if !ok {
+		return nil
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,167 @@
+package sqldatabase
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/dave/jsgo/server/store"
+)
+
+func TestRebind(t *testing.T) {
+	d := &Database{dialect: Postgres}
+	if q := d.rebind("a = ? AND b = ?"); q != "a = $1 AND b = $2" {
+		t.Fatalf("unexpected query %q", q)
+	}
+}
+
+// TestDatabase needs a driver, so it's skipped unless the test is built with the sqlite tag, or with
+// the postgres tag and SQL_TEST_URL set.
+func TestDatabase(t *testing.T) {
+	ctx := context.Background()
+	driver, source := "sqlite3", ""
+	if url := os.Getenv("SQL_TEST_URL"); url != "" {
+		driver, source = "postgres", url
+	}
+	var registered bool
+	for _, d := range sql.Drivers() {
+		registered = registered || d == driver
+	}
+	if !registered {
+		t.Skipf("%s driver not registered", driver)
+	}
+	if driver == "sqlite3" {
+		dir, err := ioutil.TempDir("", "sqldatabase")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		source = filepath.Join(dir, "test.db")
+	}
+
+	suffix := time.Now().Format("150405")
+	compileKind, historyKind := "TestCompile"+suffix, "TestHistory"+suffix
+	d, err := Open(ctx, driver, source, []string{compileKind, historyKind})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	var ids []int64
+	for i, path := range []string{"a", "b", "c"} {
+		key, err := d.Put(ctx, datastore.IncompleteKey(compileKind, nil), &store.CompileData{Path: path, Time: now.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID == 0 {
+			t.Fatal("expected an ID")
+		}
+		ids = append(ids, key.ID)
+	}
+
+	var data store.CompileData
+	if err := d.Get(ctx, datastore.IDKey(compileKind, ids[1], nil), &data); err != nil || data.Path != "b" {
+		t.Fatalf("unexpected compile %#v (%v)", data, err)
+	}
+	if err := d.Get(ctx, datastore.NameKey(historyKind, "a", nil), &store.History{}); err != datastore.ErrNoSuchEntity {
+		t.Fatalf("expected ErrNoSuchEntity, got %v", err)
+	}
+
+	// Overwrite a named entity.
+	for _, h := range []store.History{{Compiles: []int64{1}}, {Compiles: ids}} {
+		if _, err := d.Put(ctx, datastore.NameKey(historyKind, "a", nil), &h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var history store.History
+	if err := d.Get(ctx, datastore.NameKey(historyKind, "a", nil), &history); err != nil || len(history.Compiles) != 3 {
+		t.Fatalf("unexpected history %#v (%v)", history, err)
+	}
+
+	// An ID collision retries with another ID rather than overwriting the existing compile.
+	defer func(f func() int64) { newID = f }(newID)
+	next := []int64{ids[0], ids[0] + 1}
+	newID = func() int64 {
+		id := next[0]
+		next = next[1:]
+		return id
+	}
+	key, err := d.Put(ctx, datastore.IncompleteKey(compileKind, nil), &store.CompileData{Path: "d", Time: now})
+	if err != nil || key.ID != ids[0]+1 {
+		t.Fatalf("unexpected key %v (%v)", key, err)
+	}
+	if err := d.Get(ctx, datastore.IDKey(compileKind, ids[0], nil), &data); err != nil || data.Path != "a" {
+		t.Fatalf("unexpected compile %#v (%v)", data, err)
+	}
+
+	keys := []*datastore.Key{
+		datastore.IDKey(compileKind, ids[2], nil),
+		datastore.IDKey(compileKind, 1, nil),
+		datastore.IDKey(compileKind, ids[0], nil),
+	}
+	multi := make([]store.CompileData, len(keys))
+	err = d.GetMulti(ctx, keys, multi)
+	me, ok := err.(datastore.MultiError)
+	if !ok || me[0] != nil || me[1] != datastore.ErrNoSuchEntity || me[2] != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if multi[0].Path != "c" || multi[2].Path != "a" {
+		t.Fatalf("unexpected compiles %#v", multi)
+	}
+
+	if _, err := d.PutMulti(ctx, []*datastore.Key{datastore.NameKey(historyKind, "b", nil), datastore.NameKey(historyKind, "c", nil)}, []store.History{{}, {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var recent []store.CompileData
+	recentKeys, err := d.Recent(ctx, compileKind, store.Query{Since: now.Add(time.Second), Limit: 10}, &recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Path != "c" || recent[1].Path != "b" || recentKeys[0].ID != ids[2] {
+		t.Fatalf("unexpected recent compiles %#v", recent)
+	}
+}
+
+// TestMigrate checks the path and ip columns are added to a table created before they existed. It
+// needs the sqlite driver.
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	var registered bool
+	for _, d := range sql.Drivers() {
+		registered = registered || d == "sqlite3"
+	}
+	if !registered {
+		t.Skip("sqlite3 driver not registered")
+	}
+	dir, err := ioutil.TempDir("", "sqldatabase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, s := range []string{
+		`CREATE TABLE "Compile" (id BIGINT NOT NULL, name TEXT NOT NULL, time BIGINT, data TEXT NOT NULL, PRIMARY KEY (id, name))`,
+		`INSERT INTO "Compile" (id, name, time, data) VALUES (1, '', 0, '{"Path": "a", "Ip": "1.1.1.1"}')`,
+	} {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := New(db, Sqlite, []string{"Compile"}).Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var path, ip string
+	if err := db.QueryRow(`SELECT path, ip FROM "Compile" WHERE id = 1`).Scan(&path, &ip); err != nil || path != "a" || ip != "1.1.1.1" {
+		t.Fatalf("unexpected path %q and ip %q (%v)", path, ip, err)
+	}
+}
@@ -0,0 +1,7 @@
+// +build sqlite
+
+package sqldatabase
+
+import (
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+)
@@ -0,0 +1,15 @@
+package store
+
+import "context"
+
+// Database stores entities by key. services.Database uses cloud datastore keys and errors, so it's
+// adapted to this by the dsstore package, and the rest of this package only uses Key and ErrNotFound.
+type Database interface {
+	// Get loads the entity into dst, or returns ErrNotFound if it doesn't exist.
+	Get(ctx context.Context, key Key, dst interface{}) error
+	// Put stores the entity and returns its key, which is given an ID if it was incomplete.
+	Put(ctx context.Context, key Key, src interface{}) (Key, error)
+	// GetMulti loads the entities into dst (a slice), and reports which were found. Missing entities
+	// aren't an error.
+	GetMulti(ctx context.Context, keys []Key, dst interface{}) ([]bool, error)
+}
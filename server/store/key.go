@@ -0,0 +1,44 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrNotFound is returned when an entity doesn't exist.
+var ErrNotFound = errors.New("entity not found")
+
+// Key identifies an entity by its kind and either a name or a numeric ID. An incomplete key (with
+// neither) is given an ID when the entity is stored.
+type Key struct {
+	Kind string
+	Name string
+	ID   int64
+}
+
+// NameKey returns the key of an entity with a name.
+func NameKey(kind, name string) Key {
+	return Key{Kind: kind, Name: name}
+}
+
+// IDKey returns the key of an entity with a numeric ID.
+func IDKey(kind string, id int64) Key {
+	return Key{Kind: kind, ID: id}
+}
+
+// IncompleteKey returns a key that is given an ID when the entity is stored.
+func IncompleteKey(kind string) Key {
+	return Key{Kind: kind}
+}
+
+// Incomplete returns true if the key has neither a name nor an ID.
+func (k Key) Incomplete() bool {
+	return k.Name == "" && k.ID == 0
+}
+
+func (k Key) String() string {
+	if k.Name != "" {
+		return k.Kind + "/" + strconv.Quote(k.Name)
+	}
+	return k.Kind + "/" + strconv.FormatInt(k.ID, 10)
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dave/jsgo/config"
+)
+
+// Querier gets the recent entities of a kind. Database has no queries, so there's a Querier
+// for each database (see dsstore.NewGcsQuerier, NewLocalQuerier and sqldatabase).
+type Querier interface {
+	// Recent gets the entities of the kind matching the query, newest first. dst must be a pointer to a
+	// slice of structs with Time and Ip fields.
+	Recent(ctx context.Context, kind string, q Query, dst interface{}) ([]Key, error)
+}
+
+// Query selects the entities returned by Querier.Recent.
+type Query struct {
+	Since time.Time // Entities with a Time at or after this
+	Until time.Time // Entities with a Time at or before this, unless zero (used to page through results)
+	Ip    string    // Entities with this Ip, unless empty
+	Limit int       // Maximum number of entities
+}
+
+func (q Query) match(t time.Time, ip string) bool {
+	return !t.Before(q.Since) && (q.Until.IsZero() || !t.After(q.Until)) && (q.Ip == "" || ip == q.Ip)
+}
+
+// NewLocalQuerier queries the files written by localdatabase in dir (which must already be expanded).
+// Each query reads all the entities of the kind, so it's only suitable for local development.
+func NewLocalQuerier(dir string) Querier {
+	return &localQuerier{dir: dir}
+}
+
+type localQuerier struct {
+	dir string
+}
+
+func (q *localQuerier) Recent(ctx context.Context, kind string, query Query, dst interface{}) ([]Key, error) {
+	slice := reflect.ValueOf(dst)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice || slice.Elem().Type().Elem().Kind() != reflect.Struct {
+		return nil, errors.New("dst must be a pointer to a slice of structs")
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+
+	// localdatabase stores each entity in <dir>/datastore/<kind>/<id or name>.json
+	dir := filepath.Join(q.dir, "datastore", url.PathEscape(kind))
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type entity struct {
+		key   Key
+		time  time.Time
+		value reflect.Value
+	}
+	var entities []entity
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(elemType)
+		if err := json.Unmarshal(b, v.Interface()); err != nil {
+			return nil, err
+		}
+		t, _ := v.Elem().FieldByName("Time").Interface().(time.Time)
+		ip, _ := v.Elem().FieldByName("Ip").Interface().(string)
+		if !query.match(t, ip) {
+			continue
+		}
+		entities = append(entities, entity{key: localKey(kind, strings.TrimSuffix(f.Name(), ".json")), time: t, value: v.Elem()})
+	}
+	sort.Slice(entities, func(i, j int) bool { return entities[i].time.After(entities[j].time) })
+	if len(entities) > query.Limit {
+		entities = entities[:query.Limit]
+	}
+
+	var keys []Key
+	for _, e := range entities {
+		keys = append(keys, e.key)
+		slice.Set(reflect.Append(slice, e.value))
+	}
+	return keys, nil
+}
+
+// localKey returns the key of an entity from its file name.
+func localKey(kind, name string) Key {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return IDKey(kind, id)
+	}
+	name, _ = url.PathUnescape(name)
+	return NameKey(kind, name)
+}
+
+// Filter selects the records returned by the admin queries.
+type Filter struct {
+	Since time.Time // Records stored at or after this time
+	Path  string    // Substring of the package path (compiles and deploys) or the error message (errors)
+	Ip    string    // Client IP
+	Limit int       // Maximum number of records returned
+}
+
+func (f Filter) path(paths ...string) bool {
+	if f.Path == "" {
+		return true
+	}
+	for _, p := range paths {
+		if strings.Contains(p, f.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// recent gets the entities of the kind matching the filter into dst (a pointer to a slice), newest
+// first. The IP filter is applied by the querier, and the path filter by match, which is given each
+// entity. The querier is called for pages of config.AdminQueryLimit entities until it reaches Since, or
+// the limit in the filter is reached.
+func recent(ctx context.Context, querier Querier, kind string, f Filter, dst interface{}, match func(v interface{}) bool) ([]Key, error) {
+	out := reflect.ValueOf(dst).Elem()
+	q := Query{Since: f.Since, Ip: f.Ip, Limit: config.AdminQueryLimit}
+	seen := map[Key]bool{}
+	var keys []Key
+	for {
+		page := reflect.New(out.Type())
+		pageKeys, err := querier.Recent(ctx, kind, q, page.Interface())
+		if err != nil {
+			return nil, err
+		}
+		var last time.Time
+		var found bool
+		for i, key := range pageKeys {
+			// Until is inclusive so entities with the same Time as the end of the last page aren't
+			// missed, so they're skipped here.
+			if seen[key] {
+				continue
+			}
+			seen[key], found = true, true
+			v := page.Elem().Index(i)
+			last = v.FieldByName("Time").Interface().(time.Time)
+			if match(v.Interface()) {
+				keys = append(keys, key)
+				out.Set(reflect.Append(out, v))
+				if f.Limit > 0 && len(keys) >= f.Limit {
+					return keys, nil
+				}
+			}
+		}
+		if len(pageKeys) < q.Limit {
+			return keys, nil
+		}
+		if found {
+			q.Until = last
+		} else {
+			// The whole page has the same Time, so skip the rest with that Time.
+			q.Until = q.Until.Add(-time.Nanosecond)
+		}
+	}
+}
+
+// limit returns n, or the limit in the filter if it's smaller.
+func (f Filter) limit(n int) int {
+	if f.Limit > 0 && f.Limit < n {
+		return f.Limit
+	}
+	return n
+}
+
+// CompileRecord is a compile with the ID of its entity.
+type CompileRecord struct {
+	ID int64
+	CompileData
+}
+
+// RecentCompiles returns the compiles matching the filter, newest first.
+func RecentCompiles(ctx context.Context, querier Querier, f Filter) ([]CompileRecord, error) {
+	var data []CompileData
+	keys, err := recent(ctx, querier, config.CompileKind, f, &data, func(v interface{}) bool {
+		return f.path(v.(CompileData).Path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []CompileRecord
+	for i, d := range data {
+		out = append(out, CompileRecord{ID: keys[i].ID, CompileData: d})
+	}
+	return out, nil
+}
+
+// RecentDeploys returns the playground deploys matching the filter, newest first. The path filter
+// matches any of the deployed packages.
+func RecentDeploys(ctx context.Context, querier Querier, f Filter) ([]DeployData, error) {
+	var data []DeployData
+	_, err := recent(ctx, querier, config.DeployKind, f, &data, func(v interface{}) bool {
+		var paths []string
+		for _, p := range v.(DeployData).Contents.Packages {
+			paths = append(paths, p.Path)
+		}
+		return f.path(paths...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RecentShares returns the playground shares matching the filter, newest first. Shares have no path,
+// so the path filter is ignored.
+func RecentShares(ctx context.Context, querier Querier, f Filter) ([]ShareData, error) {
+	var data []ShareData
+	if _, err := recent(ctx, querier, config.ShareKind, f, &data, all); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RecentWasmDeploys returns the wasm deploys matching the filter, newest first. Wasm deploys have no
+// path, so the path filter is ignored.
+func RecentWasmDeploys(ctx context.Context, querier Querier, f Filter) ([]WasmDeploy, error) {
+	var data []WasmDeploy
+	if _, err := recent(ctx, querier, config.WasmDeployKind, f, &data, all); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RecentErrors returns the errors matching the filter, newest first. The path filter matches the error
+// message.
+func RecentErrors(ctx context.Context, querier Querier, f Filter) ([]Error, error) {
+	var data []Error
+	_, err := recent(ctx, querier, config.ErrorKind, f, &data, func(v interface{}) bool {
+		return f.path(v.(Error).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// all matches every entity, for kinds with no path.
+func all(interface{}) bool {
+	return true
+}
+
+// Frequency is the number of records with the same key (an error message or a package path).
+type Frequency struct {
+	Key   string
+	Count int
+	Last  time.Time
+}
+
+// ErrorFrequencies counts the errors matching the filter by the first line of the message, most
+// frequent first.
+func ErrorFrequencies(ctx context.Context, querier Querier, f Filter) ([]Frequency, error) {
+	errs, err := RecentErrors(ctx, querier, Filter{Since: f.Since, Path: f.Path, Ip: f.Ip})
+	if err != nil {
+		return nil, err
+	}
+	c := counter{}
+	for _, e := range errs {
+		c.add(strings.SplitN(e.Error, "\n", 2)[0], e.Time)
+	}
+	return c.sorted(f), nil
+}
+
+// TopPackages counts the compiles matching the filter by package path, most frequent first.
+func TopPackages(ctx context.Context, querier Querier, f Filter) ([]Frequency, error) {
+	compiles, err := RecentCompiles(ctx, querier, Filter{Since: f.Since, Path: f.Path, Ip: f.Ip})
+	if err != nil {
+		return nil, err
+	}
+	c := counter{}
+	for _, compile := range compiles {
+		c.add(compile.Path, compile.Time)
+	}
+	return c.sorted(f), nil
+}
+
+type counter map[string]*Frequency
+
+func (c counter) add(key string, t time.Time) {
+	f, ok := c[key]
+	if !ok {
+		f = &Frequency{Key: key}
+		c[key] = f
+	}
+	f.Count++
+	if t.After(f.Last) {
+		f.Last = t
+	}
+}
+
+func (c counter) sorted(f Filter) []Frequency {
+	var out []Frequency
+	for _, v := range c {
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out[:f.limit(len(out))]
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dave/jsgo/config"
+)
+
+// sliceQuerier is a Querier of compiles in memory.
+type sliceQuerier []CompileData
+
+func (s sliceQuerier) Recent(ctx context.Context, kind string, q Query, dst interface{}) ([]Key, error) {
+	var keys []Key
+	out := dst.(*[]CompileData)
+	for i := len(s) - 1; i >= 0 && len(keys) < q.Limit; i-- {
+		if q.match(s[i].Time, s[i].Ip) {
+			keys = append(keys, IDKey(kind, int64(i+1)))
+			*out = append(*out, s[i])
+		}
+	}
+	return keys, nil
+}
+
+func TestRecentPages(t *testing.T) {
+	now := time.Now()
+	var s sliceQuerier
+	for i := 0; i < config.AdminQueryLimit*3; i++ {
+		// Compiles are oldest first, and pairs of compiles have the same time.
+		d := CompileData{Path: "github.com/b/b", Ip: "1.1.1.1", Time: now.Add(time.Duration(i/2) * time.Second)}
+		if i < 3 || i == config.AdminQueryLimit*2 {
+			d.Path = "github.com/a/a"
+		}
+		s = append(s, d)
+	}
+	records, err := RecentCompiles(context.Background(), s, Filter{Since: now, Path: "/a/", Ip: "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[0].ID != config.AdminQueryLimit*2+1 || records[3].ID != 1 {
+		t.Fatalf("unexpected compiles %#v", records)
+	}
+	top, err := TopPackages(context.Background(), s, Filter{Since: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].Count != config.AdminQueryLimit*3-4 || top[1].Count != 4 {
+		t.Fatalf("unexpected top packages %#v", top)
+	}
+}
@@ -2,11 +2,11 @@ package store
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
 	"github.com/dave/jsgo/config"
-	"github.com/dave/services"
 )
 
 type Error struct {
@@ -23,14 +23,42 @@ type ShareData struct {
 }
 
 type CompileData struct {
-	Path string
-	Time time.Time
-	Min  CompileContents
-	Max  CompileContents
-	Ip   string
+	Path    string
+	Version string          // Branch, tag or commit requested, or empty for the default branch
+	Commit  string          // Commit that was built when Version is set
+	Modules []CompileModule // Module versions used, when the package is in a module
+	Key     string          // Hash of the commits in the dependency tree and the build options (see jsgo.cacheKey)
+	Time    time.Time
+	Min     CompileContents
+	Max     CompileContents
+	Ip      string
+	Client  string // Client that made the compile (see clientip.Get). Only it can promote the compile.
+
+	Tags       []string // Build tags
+	MinifyOnly bool     // The unminified version wasn't built
 
 	Success bool
-	Error   string
+	Error   string         `datastore:",noindex"`
+	Phase   string         // Phase that failed (see servermsg.PhaseDownload etc.)
+	Errors  []CompileError // Errors parsed from the output of the failed phase
+}
+
+// CompileModule is the version of a module used by a compile. The datastore can't store maps, so
+// messages.Complete.Modules is stored as a slice of these.
+type CompileModule struct {
+	Path    string
+	Version string
+}
+
+// CompileError is an error at a source location, with the surrounding lines of source.
+type CompileError struct {
+	Package string
+	File    string
+	Line    int
+	Column  int
+	Message string   `datastore:",noindex"`
+	Source  []string `datastore:",noindex"`
+	Start   int      // Line number of the first line in Source
 }
 
 type DeployData struct {
@@ -42,9 +70,15 @@ type DeployData struct {
 
 type CompileContents struct {
 	Main     string
+	Index    string // Hash of the index page. A copy is stored by hash so the compile can be promoted later.
 	Packages []CompilePackage
 }
 
+// History lists the compiles of a package (IDs of CompileKind entities), newest first.
+type History struct {
+	Compiles []int64
+}
+
 type DeployContents struct {
 	Index    string
 	Main     string
@@ -68,48 +102,94 @@ type WasmDeployFile struct {
 	Hash string
 }
 
-func StoreError(ctx context.Context, database services.Database, data Error) error {
+func StoreError(ctx context.Context, database Database, data Error) error {
 	if _, err := database.Put(ctx, errorKey(), &data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func StoreShare(ctx context.Context, database services.Database, data ShareData) error {
+func StoreShare(ctx context.Context, database Database, data ShareData) error {
 	if _, err := database.Put(ctx, shareKey(), &data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func StoreDeploy(ctx context.Context, database services.Database, data DeployData) error {
+func StoreDeploy(ctx context.Context, database Database, data DeployData) error {
 	if _, err := database.Put(ctx, deployKey(), &data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func StoreCompile(ctx context.Context, database services.Database, path string, data CompileData) error {
+func StoreCompile(ctx context.Context, database Database, path string, data CompileData) error {
+	key, err := database.Put(ctx, compileKey(), &data)
+	if err != nil {
+		return err
+	}
+	if _, err := database.Put(ctx, packageKey(path), &data); err != nil {
+		return err
+	}
+	// The database has no transactions, so concurrent compiles of the same path might lose a history
+	// entry. The compile itself is still stored.
+	var history History
+	if err := database.Get(ctx, historyKey(path), &history); err != nil && err != ErrNotFound {
+		return err
+	}
+	history.Compiles = append([]int64{key.ID}, history.Compiles...)
+	if len(history.Compiles) > config.CompileHistorySize {
+		history.Compiles = history.Compiles[:config.CompileHistorySize]
+	}
+	if _, err := database.Put(ctx, historyKey(path), &history); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StoreFailure stores a failed compile. The package entity isn't changed, so the last successful
+// compile stays live.
+func StoreFailure(ctx context.Context, database Database, path string, data CompileData) error {
 	if _, err := database.Put(ctx, compileKey(), &data); err != nil {
 		return err
 	}
+	if _, err := database.Put(ctx, failureKey(path), &data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Failure returns the latest failed compile of a package.
+func Failure(ctx context.Context, database Database, path string) (bool, CompileData, error) {
+	var data CompileData
+	if err := database.Get(ctx, failureKey(path), &data); err != nil {
+		if err == ErrNotFound {
+			return false, CompileData{}, nil
+		}
+		return false, CompileData{}, err
+	}
+	return true, data, nil
+}
+
+// Promote makes a previous compile the current compile of the package.
+func Promote(ctx context.Context, database Database, path string, data CompileData) error {
 	if _, err := database.Put(ctx, packageKey(path), &data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func StoreWasmDeploy(ctx context.Context, database services.Database, data WasmDeploy) error {
+func StoreWasmDeploy(ctx context.Context, database Database, data WasmDeploy) error {
 	if _, err := database.Put(ctx, wasmDeployKey(), &data); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Package(ctx context.Context, database services.Database, path string) (bool, CompileData, error) {
+func Package(ctx context.Context, database Database, path string) (bool, CompileData, error) {
 	var data CompileData
 	if err := database.Get(ctx, packageKey(path), &data); err != nil {
-		if err == datastore.ErrNoSuchEntity {
+		if err == ErrNotFound {
 			return false, CompileData{}, nil
 		}
 		return false, CompileData{}, err
@@ -117,26 +197,108 @@ func Package(ctx context.Context, database services.Database, path string) (bool
 	return true, data, nil
 }
 
-func errorKey() *datastore.Key {
-	return datastore.IncompleteKey(config.ErrorKind, nil)
+// CompileHistory returns the IDs and data of the previous compiles of a package, newest first. Compiles
+// that are missing from the database are skipped.
+func CompileHistory(ctx context.Context, database Database, path string) ([]int64, []CompileData, error) {
+	var history History
+	if err := database.Get(ctx, historyKey(path), &history); err != nil {
+		if err == ErrNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	keys := make([]Key, len(history.Compiles))
+	for i, id := range history.Compiles {
+		keys[i] = IDKey(config.CompileKind, id)
+	}
+	data := make([]CompileData, len(keys))
+	found, err := database.GetMulti(ctx, keys, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []int64
+	var compiles []CompileData
+	for i, id := range history.Compiles {
+		if found[i] {
+			ids = append(ids, id)
+			compiles = append(compiles, data[i])
+		}
+	}
+	return ids, compiles, nil
+}
+
+// Compile returns a compile by ID.
+func Compile(ctx context.Context, database Database, id int64) (bool, CompileData, error) {
+	var data CompileData
+	if err := database.Get(ctx, IDKey(config.CompileKind, id), &data); err != nil {
+		if err == ErrNotFound {
+			return false, CompileData{}, nil
+		}
+		return false, CompileData{}, err
+	}
+	return true, data, nil
+}
+
+// PackageName is the name of the package key for a compile. Compiles pinned to a version, with build
+// tags or without the unminified version are stored separately from the default compile of the path
+// (e.g. "github.com/foo/bar@v1.2.0 tags=a,b min"), and their index pages are stored by hash.
+func PackageName(path, version string, tags []string, minifyOnly bool) string {
+	name := path
+	if version != "" {
+		name += "@" + version
+	}
+	if tags = normalizeTags(tags); len(tags) > 0 {
+		name += " tags=" + strings.Join(tags, ",")
+	}
+	if minifyOnly {
+		name += " min"
+	}
+	return name
+}
+
+// normalizeTags sorts the tags and removes duplicates and empty tags.
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func errorKey() Key {
+	return IncompleteKey(config.ErrorKind)
+}
+
+func compileKey() Key {
+	return IncompleteKey(config.CompileKind)
+}
+
+func wasmDeployKey() Key {
+	return IncompleteKey(config.WasmDeployKind)
 }
 
-func compileKey() *datastore.Key {
-	return datastore.IncompleteKey(config.CompileKind, nil)
+func deployKey() Key {
+	return IncompleteKey(config.DeployKind)
 }
 
-func wasmDeployKey() *datastore.Key {
-	return datastore.IncompleteKey(config.WasmDeployKind, nil)
+func shareKey() Key {
+	return IncompleteKey(config.ShareKind)
 }
 
-func deployKey() *datastore.Key {
-	return datastore.IncompleteKey(config.DeployKind, nil)
+func failureKey(path string) Key {
+	return NameKey(config.FailureKind, path)
 }
 
-func shareKey() *datastore.Key {
-	return datastore.IncompleteKey(config.ShareKind, nil)
+func historyKey(path string) Key {
+	return NameKey(config.HistoryKind, path)
 }
 
-func packageKey(path string) *datastore.Key {
-	return datastore.NameKey(config.PackageKind, path, nil)
+func packageKey(path string) Key {
+	return NameKey(config.PackageKind, path)
 }
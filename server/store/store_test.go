@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// memDatabase is a Database in memory.
+type memDatabase struct {
+	entities map[Key][]byte
+	id       int64
+}
+
+func (m *memDatabase) Get(ctx context.Context, key Key, dst interface{}) error {
+	b, ok := m.entities[key]
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func (m *memDatabase) Put(ctx context.Context, key Key, src interface{}) (Key, error) {
+	if key.Incomplete() {
+		m.id++
+		key.ID = m.id
+	}
+	b, err := json.Marshal(src)
+	if err != nil {
+		return Key{}, err
+	}
+	if m.entities == nil {
+		m.entities = map[Key][]byte{}
+	}
+	m.entities[key] = b
+	return key, nil
+}
+
+func (m *memDatabase) GetMulti(ctx context.Context, keys []Key, dst interface{}) ([]bool, error) {
+	v := reflect.ValueOf(dst)
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		err := m.Get(ctx, key, v.Index(i).Addr().Interface())
+		switch err {
+		case nil:
+			found[i] = true
+		case ErrNotFound:
+		default:
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func TestCompileHistory(t *testing.T) {
+	ctx := context.Background()
+	database := &memDatabase{}
+	for _, commit := range []string{"a", "b"} {
+		if err := StoreCompile(ctx, database, "github.com/a/a", CompileData{Path: "github.com/a/a", Commit: commit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A compile that's missing from the database (e.g. deleted) is skipped.
+	var history History
+	if err := database.Get(ctx, historyKey("github.com/a/a"), &history); err != nil {
+		t.Fatal(err)
+	}
+	history.Compiles = append([]int64{12345}, history.Compiles...)
+	if _, err := database.Put(ctx, historyKey("github.com/a/a"), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, data, err := CompileHistory(ctx, database, "github.com/a/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != history.Compiles[1] || data[0].Commit != "b" || data[1].Commit != "a" {
+		t.Fatalf("unexpected history %v %#v", ids, data)
+	}
+}
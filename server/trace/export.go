@@ -0,0 +1,178 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+)
+
+// NewOtlp returns an exporter that posts spans to an OTLP collector using the JSON encoding over HTTP
+// (e.g. "http://localhost:4318").
+func NewOtlp(endpoint string) Exporter {
+	return &otlpExporter{url: strings.TrimSuffix(endpoint, "/") + "/v1/traces"}
+}
+
+type otlpExporter struct {
+	url string
+}
+
+func (e *otlpExporter) Export(spans []*Span) error {
+	b, err := json.Marshal(request(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.TraceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exporting spans: %s", resp.Status)
+	}
+	return nil
+}
+
+// NewFile returns an exporter that appends spans to a file, one OTLP JSON request per line (the same
+// format as the file exporter of the OpenTelemetry collector).
+func NewFile(name string) (Exporter, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{w: f}, nil
+}
+
+type fileExporter struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+func (e *fileExporter) Export(spans []*Span) error {
+	b, err := json.Marshal(request(spans))
+	if err != nil {
+		return err
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// The OTLP JSON encoding (ExportTraceServiceRequest). IDs are hex encoded and times are nanoseconds
+// encoded as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+func request(spans []*Span) otlpRequest {
+	var out []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.Error != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		out = append(out, o)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes(map[string]string{"service.name": "jsgo"})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/dave/jsgo"}, Spans: out}},
+	}}}
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range m {
+		out = append(out, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// NewFileserver wraps a fileserver to record a span for each file written by a job (e.g. each constor
+// upload).
+func NewFileserver(fileserver services.Fileserver) *Fileserver {
+	return &Fileserver{Fileserver: fileserver}
+}
+
+type Fileserver struct {
+	services.Fileserver
+}
+
+func (f *Fileserver) Write(ctx context.Context, bucket, name string, reader io.Reader, overwrite bool, contentType, cacheControl string) (saved bool, err error) {
+	err = Run(ctx, "store", func(ctx context.Context) error {
+		saved, err = f.Fileserver.Write(ctx, bucket, name, reader, overwrite, contentType, cacheControl)
+		return err
+	}, "bucket", bucket, "name", name)
+	return saved, err
+}
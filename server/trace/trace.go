@@ -0,0 +1,188 @@
+// Package trace records spans for each job, in the style of OpenTelemetry. Spans are exported in
+// batches to an OTLP collector or a file (see the exporters).
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/dave/jsgo/config"
+	"github.com/dave/services"
+	"github.com/dave/services/builder/buildermsg"
+	"github.com/dave/services/getter/gettermsg"
+)
+
+// Span is a timed operation in a trace.
+type Span struct {
+	TraceID    string // 32 hex digits
+	SpanID     string // 16 hex digits
+	ParentID   string // empty for the root span
+	Name       string
+	Start, End time.Time
+	Attributes map[string]string
+	Error      string
+}
+
+type spanKey struct{}
+
+// Start starts a span, as a child of the span in the context if there is one. Attributes are key /
+// value pairs.
+func Start(ctx context.Context, name string, attributes ...string) (context.Context, *Span) {
+	s := &Span{
+		SpanID:     id(8),
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		s.TraceID = id(16)
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.Attributes[attributes[i]] = attributes[i+1]
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Run runs f in a child span of the span in the context, and records the error if f fails. If the
+// context has no span, f is run without one.
+func Run(ctx context.Context, name string, f func(ctx context.Context) error, attributes ...string) error {
+	if TraceID(ctx) == "" {
+		return f(ctx)
+	}
+	ctx, s := Start(ctx, name, attributes...)
+	defer s.Finish()
+	err := f(ctx)
+	s.Fail(err)
+	return err
+}
+
+// TraceID returns the trace ID of the span in the context, or an empty string.
+func TraceID(ctx context.Context) string {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return s.TraceID
+	}
+	return ""
+}
+
+// Fail records the error if it's not nil.
+func (s *Span) Fail(err error) {
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Finish ends the span and queues it for export. The span should not be changed afterwards.
+func (s *Span) Finish() {
+	s.End = time.Now()
+	batch.add(s)
+}
+
+func id(bytes int) string {
+	b := make([]byte, bytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Exporter sends finished spans to a collector.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+var batch = &batcher{}
+
+type batcher struct {
+	m        sync.Mutex
+	exporter Exporter
+	pending  []*Span
+	ticker   sync.Once
+}
+
+// SetExporter sets the exporter and starts exporting finished spans every config.TraceExportInterval.
+// Spans are discarded if no exporter is set. Calling it again replaces the exporter.
+func SetExporter(e Exporter) {
+	batch.m.Lock()
+	batch.exporter = e
+	batch.m.Unlock()
+	batch.ticker.Do(func() {
+		go func() {
+			for range time.NewTicker(config.TraceExportInterval).C {
+				Flush()
+			}
+		}()
+	})
+}
+
+func (b *batcher) add(s *Span) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.exporter == nil {
+		return
+	}
+	if len(b.pending) >= config.TraceMaxPending {
+		// The exporter can't keep up, so drop the span rather than use unbounded memory.
+		return
+	}
+	b.pending = append(b.pending, s)
+}
+
+// Flush exports the finished spans. Errors are ignored - tracing shouldn't affect the jobs.
+func Flush() {
+	batch.m.Lock()
+	e, spans := batch.exporter, batch.pending
+	batch.pending = nil
+	batch.m.Unlock()
+	if e == nil || len(spans) == 0 {
+		return
+	}
+	e.Export(spans)
+}
+
+// Builds wraps the send function passed to the deployer, and records a span for each package from the
+// buildermsg.Building progress messages. The returned function ends the last span.
+func Builds(ctx context.Context, send func(services.Message)) (func(services.Message), func()) {
+	return progress(ctx, send, "build", "package", func(message services.Message) (string, bool) {
+		b, ok := message.(buildermsg.Building)
+		return b.Message, ok && (b.Message != "" || b.Done)
+	})
+}
+
+// Gets wraps the send function passed to the getter, and records a span for each repo from the
+// gettermsg.Downloading progress messages. The returned function ends the last span, so call it when
+// get.Get returns.
+func Gets(ctx context.Context, send func(services.Message)) (func(services.Message), func()) {
+	return progress(ctx, send, "download", "repo", func(message services.Message) (string, bool) {
+		d, ok := message.(gettermsg.Downloading)
+		return d.Message, ok && (d.Message != "" || d.Done)
+	})
+}
+
+// progress records a span for each item from the progress messages. step returns the item of a
+// message (empty when the last item is done), and false for messages that don't change the item.
+func progress(ctx context.Context, send func(services.Message), name, attribute string, step func(services.Message) (string, bool)) (func(services.Message), func()) {
+	var m sync.Mutex
+	var current *Span
+	end := func() {
+		m.Lock()
+		defer m.Unlock()
+		if current != nil {
+			current.Finish()
+			current = nil
+		}
+	}
+	return func(message services.Message) {
+		if item, ok := step(message); ok {
+			end()
+			if item != "" {
+				m.Lock()
+				_, current = Start(ctx, name, attribute, item)
+				m.Unlock()
+			}
+		}
+		send(message)
+	}, end
+}
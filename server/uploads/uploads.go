@@ -0,0 +1,100 @@
+// Package uploads records the files overwritten by a job, so they can be rolled back if the job is
+// cancelled before it finishes.
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/dave/services"
+)
+
+// DeleteFunc deletes a file from a bucket. services.Fileserver has no Delete method, so each fileserver
+// provides its own.
+type DeleteFunc func(ctx context.Context, bucket, name string) error
+
+// New wraps a fileserver. Files overwritten with a context created with NewContext are recorded.
+func New(fileserver services.Fileserver, delete DeleteFunc) *Fileserver {
+	return &Fileserver{Fileserver: fileserver, delete: delete}
+}
+
+type Fileserver struct {
+	services.Fileserver
+	delete DeleteFunc
+}
+
+// file is a file overwritten by a job, with its contents before the job if it existed.
+type file struct {
+	bucket, name              string
+	contentType, cacheControl string
+	existed                   bool
+	previous                  []byte
+}
+
+// Journal is the files overwritten by a job.
+type Journal struct {
+	m        sync.Mutex
+	files    []file
+	recorded map[[2]string]bool
+	server   *Fileserver
+}
+
+type journalKey struct{}
+
+// NewContext returns a context that records the files overwritten with it.
+func NewContext(ctx context.Context) (context.Context, *Journal) {
+	j := &Journal{recorded: map[[2]string]bool{}}
+	return context.WithValue(ctx, journalKey{}, j), j
+}
+
+// Write records the previous contents of the files that are overwritten (e.g. index pages), so Clean
+// can roll them back. Immutable files (written with overwrite == false) are named by the hash of their
+// contents and may be referenced by other jobs as soon as they exist, so they are never recorded or
+// deleted. The first overwrite of each file is recorded before it's written, so partial writes are
+// rolled back too.
+func (f *Fileserver) Write(ctx context.Context, bucket, name string, reader io.Reader, overwrite bool, contentType, cacheControl string) (saved bool, err error) {
+	j, ok := ctx.Value(journalKey{}).(*Journal)
+	if !ok || !overwrite {
+		return f.Fileserver.Write(ctx, bucket, name, reader, overwrite, contentType, cacheControl)
+	}
+	if err := j.record(ctx, f, bucket, name, contentType, cacheControl); err != nil {
+		return false, err
+	}
+	return f.Fileserver.Write(ctx, bucket, name, reader, overwrite, contentType, cacheControl)
+}
+
+func (j *Journal) record(ctx context.Context, f *Fileserver, bucket, name, contentType, cacheControl string) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	key := [2]string{bucket, name}
+	if j.recorded[key] {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	found, err := f.Fileserver.Read(ctx, bucket, name, buf)
+	if err != nil {
+		return err
+	}
+	j.server = f
+	j.recorded[key] = true
+	j.files = append(j.files, file{bucket: bucket, name: name, contentType: contentType, cacheControl: cacheControl, existed: found, previous: buf.Bytes()})
+	return nil
+}
+
+// Clean rolls back the files overwritten by the job: files that existed are restored and files that
+// didn't are deleted. Errors are ignored, because the file may not have been written.
+func (j *Journal) Clean(ctx context.Context) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	for _, file := range j.files {
+		if !file.existed {
+			j.server.delete(ctx, file.bucket, file.name)
+			continue
+		}
+		j.server.Fileserver.Write(ctx, file.bucket, file.name, bytes.NewReader(file.previous), true, file.contentType, file.cacheControl)
+	}
+	j.files = nil
+	j.recorded = map[[2]string]bool{}
+}
@@ -0,0 +1,81 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+type memory struct {
+	m     sync.Mutex
+	files map[string]string
+}
+
+func (m *memory) Write(ctx context.Context, bucket, name string, reader io.Reader, overwrite bool, contentType, cacheControl string) (bool, error) {
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false, err
+	}
+	m.m.Lock()
+	defer m.m.Unlock()
+	if _, ok := m.files[bucket+"/"+name]; ok && !overwrite {
+		return false, nil
+	}
+	m.files[bucket+"/"+name] = string(b)
+	return true, nil
+}
+
+func (m *memory) Read(ctx context.Context, bucket, name string, writer io.Writer) (bool, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	s, ok := m.files[bucket+"/"+name]
+	if ok {
+		io.WriteString(writer, s)
+	}
+	return ok, nil
+}
+
+func (m *memory) Exists(ctx context.Context, bucket, name string) (bool, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, ok := m.files[bucket+"/"+name]
+	return ok, nil
+}
+
+func (m *memory) delete(ctx context.Context, bucket, name string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	delete(m.files, bucket+"/"+name)
+	return nil
+}
+
+func TestClean(t *testing.T) {
+	m := &memory{files: map[string]string{"index/a": "old", "index/empty": ""}}
+	f := New(m, m.delete)
+	ctx, journal := NewContext(context.Background())
+	write := func(name, contents string, overwrite bool) {
+		if _, err := f.Write(ctx, "index", name, bytes.NewBufferString(contents), overwrite, "text/html", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "new", true)
+	write("a", "newer", true)
+	write("b", "new", true)
+	write("empty", "new", true)
+	write("hash", "shared", false)
+
+	journal.Clean(context.Background())
+	// An empty file is restored, not deleted.
+	expected := map[string]string{"index/a": "old", "index/empty": "", "index/hash": "shared"}
+	if len(m.files) != len(expected) {
+		t.Fatalf("unexpected files %v", m.files)
+	}
+	for k, v := range expected {
+		if m.files[k] != v {
+			t.Fatalf("unexpected files %v", m.files)
+		}
+	}
+}
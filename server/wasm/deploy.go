@@ -21,30 +21,40 @@ import (
 
 func (h *Handler) DeployQuery(ctx context.Context, info messages.DeployQuery, req *http.Request, send func(services.Message), receive chan services.Message) error {
 
+	if !supported(info.Version) {
+		send(messages.DeployClientVersionNotSupported{})
+		return nil
+	}
+
 	var m sync.Mutex
 	var required []messages.DeployFileKey
+	var outer error
 	wg := &sync.WaitGroup{}
 
 	for _, file := range info.Files {
 		file := file
-		var outer error
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			bucket, name, _ := details(file.Type, file.Hash)
 			exists, err := h.Fileserver.Exists(ctx, bucket, name)
+			m.Lock()
+			defer m.Unlock()
 			if err != nil {
-				outer = err
+				if outer == nil {
+					outer = err
+				}
 				return
 			}
 			if !exists {
-				m.Lock()
 				required = append(required, file)
-				m.Unlock()
 			}
 		}()
 	}
 	wg.Wait()
+	if outer != nil {
+		return servermsg.Failed(servermsg.PhaseStore, "", outer)
+	}
 
 	send(messages.DeployQueryResponse{Required: required})
 
@@ -55,7 +65,10 @@ func (h *Handler) DeployQuery(ctx context.Context, info messages.DeployQuery, re
 	var payload messages.DeployPayload
 	select {
 	case message := <-receive:
-		payload = message.(messages.DeployPayload)
+		var ok bool
+		if payload, ok = message.(messages.DeployPayload); !ok {
+			return servermsg.Invalid(fmt.Errorf("invalid payload message %T", message))
+		}
 	case <-ctx.Done():
 		return nil
 	}
@@ -69,11 +82,11 @@ func (h *Handler) DeployQuery(ctx context.Context, info messages.DeployQuery, re
 		// check the hash is correct
 		sha := sha1.New()
 		if _, err := io.Copy(sha, bytes.NewBuffer(f.Contents)); err != nil {
-			return err
+			return servermsg.Failed(servermsg.PhaseStore, "", err)
 		}
 		calculated := fmt.Sprintf("%x", sha.Sum(nil))
 		if calculated != f.Hash {
-			return fmt.Errorf("hash not consistent for %s", f.Type)
+			return servermsg.Invalid(fmt.Errorf("hash not consistent for %s", f.Type))
 		}
 		bucket, name, mime := details(f.Type, f.Hash)
 		storer.Add(constor.Item{
@@ -89,7 +102,7 @@ func (h *Handler) DeployQuery(ctx context.Context, info messages.DeployQuery, re
 	}
 
 	if err := storer.Wait(); err != nil {
-		return err
+		return servermsg.Failed(servermsg.PhaseStore, "", err)
 	}
 
 	send(constormsg.Storing{Done: true})
@@ -109,11 +122,21 @@ func (h *Handler) storeWasmDeploy(ctx context.Context, send func(services.Messag
 	}
 	if err := store.StoreWasmDeploy(ctx, h.Database, data); err != nil {
 		// don't save this one to the datastore because it's an error from the datastore.
-		send(servermsg.Error{Message: err.Error()})
+		send(servermsg.NewError(servermsg.Failed(servermsg.PhaseStore, "", err)))
 		return
 	}
 }
 
+// supported checks the version of the client against config.WasmClientVersions.
+func supported(version string) bool {
+	for _, v := range config.WasmClientVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
 func details(typ messages.DeployFileType, hash string) (bucket, name, mime string) {
 	switch typ {
 	case messages.DeployFileTypeIndex:
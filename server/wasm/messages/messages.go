@@ -1,33 +1,33 @@
 package messages
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/gob"
-
+	"github.com/dave/jsgo/server/codec"
 	"github.com/dave/jsgo/server/servermsg"
 	"github.com/dave/services"
 	"github.com/dave/services/constor/constormsg"
-	"github.com/gorilla/websocket"
 )
 
-type Payload struct {
-	Message services.Message
-}
+// Payload wraps the gob encoded messages.
+type Payload = codec.Payload
 
-func init() {
+// Codecs encodes the messages. Gzipped gob is the default.
+var Codecs = codec.NewRegistry(
+	codec.GzipGob,
 
 	// Commands:
-	gob.Register(DeployQuery{})
+	servermsg.Cancel{},
+	DeployQuery{},
 
 	// Data messages:
-	gob.Register(DeployQueryResponse{})
-	gob.Register(DeployFileKey{})
-	gob.Register(DeployFile{})
-	gob.Register(DeployPayload{})
-	gob.Register(DeployDone{})
-	gob.Register(DeployClientVersionNotSupported{})
+	DeployQueryResponse{},
+	DeployFileKey{},
+	DeployFile{},
+	DeployPayload{},
+	DeployDone{},
+	DeployClientVersionNotSupported{},
+)
 
+func init() {
 	// Initialise types in servermsg
 	servermsg.RegisterTypes()
 
@@ -75,29 +75,9 @@ const (
 )
 
 func Marshal(in services.Message) ([]byte, int, error) {
-	p := Payload{in}
-	buf := &bytes.Buffer{}
-	gzw := gzip.NewWriter(buf)
-	if err := gob.NewEncoder(gzw).Encode(p); err != nil {
-		return nil, 0, err
-	}
-	if err := gzw.Close(); err != nil {
-		return nil, 0, err
-	}
-	return buf.Bytes(), websocket.BinaryMessage, nil
+	return Codecs.Marshal(codec.GzipGob, in)
 }
 
 func Unmarshal(in []byte) (services.Message, error) {
-	var p Payload
-	gzr, err := gzip.NewReader(bytes.NewBuffer(in))
-	if err != nil {
-		return nil, err
-	}
-	if err := gob.NewDecoder(gzr).Decode(&p); err != nil {
-		return nil, err
-	}
-	if err := gzr.Close(); err != nil {
-		return nil, err
-	}
-	return p.Message, nil
+	return Codecs.Unmarshal(codec.GzipGob, in)
 }
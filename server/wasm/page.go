@@ -0,0 +1,8 @@
+package wasm
+
+import "net/http"
+
+// Page redirects to the wasmgo command, which deploys to this server. There's no page of its own.
+func Page(w http.ResponseWriter, req *http.Request) {
+	http.Redirect(w, req, "https://github.com/dave/wasmgo", http.StatusFound)
+}
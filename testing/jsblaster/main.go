@@ -9,11 +9,11 @@ import (
 	"log"
 	"math/rand"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/dave/blast/blaster"
 	"github.com/dave/jsgo/server/play/messages"
+	"github.com/dave/jsgo/server/servermsg"
 	"github.com/mitchellh/mapstructure"
 	"golang.org/x/net/websocket"
 )
@@ -121,6 +121,7 @@ func (w *Worker) Send(ctx context.Context, raw map[string]interface{}) (out map[
 				Type    string
 				Message struct {
 					Message string
+					Code    string
 					Done    bool
 				}
 			}
@@ -142,19 +143,14 @@ func (w *Worker) Send(ctx context.Context, raw map[string]interface{}) (out map[
 				return
 			}
 			if msg.Type == "Error" {
-				if strings.Contains(msg.Message.Message, "too many git objects") {
+				switch msg.Message.Code {
+				case servermsg.CodeTooManyGitObjects:
 					send(errors.New("too many git objects"))
-				} else if strings.Contains(msg.Message.Message, "unrecognized import path") {
+				case servermsg.CodeUnrecognizedImportPath, servermsg.CodeNoGoFiles, servermsg.CodePackageNotFound, servermsg.CodeCompileError:
 					send(errors.New("source"))
-				} else if strings.Contains(msg.Message.Message, "no Go files in") {
-					send(errors.New("source"))
-				} else if strings.Contains(msg.Message.Message, "cannot find package") {
-					send(errors.New("source"))
-				} else if strings.HasPrefix(msg.Message.Message, "gopath/src/") {
-					send(errors.New("source"))
-				} else if strings.Contains(msg.Message.Message, "bad status") {
-					send(errors.New("bad status"))
-				} else {
+				case servermsg.CodeFetchFailed:
+					send(errors.New("fetch failed"))
+				default:
 					if msg.Message.Message == "" {
 						send(errors.New(raw))
 					} else {